@@ -0,0 +1,28 @@
+package airthings
+
+// discharge curve of a pair of AA alkaline batteries, as used by Wave Plus: voltage -> percentage
+var batteryCurve = []struct {
+	voltage float32
+	percent float32
+}{
+	{3.00, 100},
+	{2.80, 81},
+	{2.60, 53},
+	{2.50, 28},
+	{2.20, 5},
+	{2.10, 0},
+}
+
+// BatteryPercent estimates the remaining battery charge from its voltage, interpolating along the discharge curve
+func BatteryPercent(voltage float32) float32 {
+	if voltage >= batteryCurve[0].voltage {
+		return batteryCurve[0].percent
+	}
+	for i := 1; i < len(batteryCurve); i++ {
+		upper, lower := batteryCurve[i-1], batteryCurve[i]
+		if voltage >= lower.voltage {
+			return lower.percent + (voltage-lower.voltage)/(upper.voltage-lower.voltage)*(upper.percent-lower.percent)
+		}
+	}
+	return 0
+}
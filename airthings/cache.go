@@ -0,0 +1,157 @@
+package airthings
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CachingScanner remembers the sensors the underlying Scanner found, and only rescans
+// once RescanInterval has passed, or after a sensor was reported as failing to connect
+type CachingScanner struct {
+	Scanner Scanner
+
+	// how long the found sensors are trusted before scanning again
+	RescanInterval time.Duration
+
+	// sensors not seen for this long are forgotten, zero means never
+	Expiry time.Duration
+
+	mu              sync.Mutex
+	entries         map[string]*CacheEntry
+	refreshed       time.Time
+	rescanRequested bool
+}
+
+// CacheEntry is what CachingScanner knows about one sensor
+type CacheEntry struct {
+	Sensor    Sensor
+	FirstSeen time.Time
+	LastSeen  time.Time
+
+	// configured rather than found, never expires
+	Pinned bool
+}
+
+func NewCachingScanner(scanner Scanner, rescanInterval time.Duration, expiry time.Duration) *CachingScanner {
+	return &CachingScanner{
+		Scanner:        scanner,
+		RescanInterval: rescanInterval,
+		Expiry:         expiry,
+		entries:        map[string]*CacheEntry{},
+	}
+}
+
+func (cache *CachingScanner) Scan() (map[string]Sensor, error) {
+	return cache.ScanContext(context.Background())
+}
+
+// ScanContext returns the cached sensors, rescanning first if the cache is due for it.
+// If the rescan fails, the error is returned along with the sensors cached so far.
+func (cache *CachingScanner) ScanContext(ctx context.Context) (map[string]Sensor, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	var err error
+	now := time.Now()
+	if cache.rescanRequested || len(cache.entries) == 0 || now.Sub(cache.refreshed) >= cache.RescanInterval {
+		var found map[string]Sensor
+		found, err = cache.Scanner.ScanContext(ctx)
+		if err == nil {
+			now = time.Now()
+			for serialNr, sensor := range found {
+				cache.seen(serialNr, sensor, now)
+			}
+			cache.refreshed = now
+			cache.rescanRequested = false
+		}
+	}
+
+	cache.expire(now)
+
+	sensors := map[string]Sensor{}
+	for serialNr, entry := range cache.entries {
+		sensors[serialNr] = entry.Sensor
+	}
+	return sensors, err
+}
+
+// Pin adds a sensor that is known upfront. It does not count as a refresh: the scans still look for
+// the sensors that are not configured, and pinned sensors are returned even when a scan fails.
+func (cache *CachingScanner) Pin(serialNr string, sensor Sensor) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.seen(serialNr, sensor, time.Now())
+	entry := cache.entries[serialNr]
+	entry.Sensor = sensor
+	entry.Pinned = true
+}
+
+// Unpin makes a sensor expire like a found one, e.g. once it is no longer configured
+func (cache *CachingScanner) Unpin(serialNr string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if entry, ok := cache.entries[serialNr]; ok {
+		entry.Pinned = false
+	}
+}
+
+// ReportSeen keeps the sensor from expiring, e.g. after a successful read
+func (cache *CachingScanner) ReportSeen(serialNr string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if entry, ok := cache.entries[serialNr]; ok {
+		entry.LastSeen = time.Now()
+	}
+}
+
+// ReportFailure makes the next ScanContext rescan, as the sensor might have moved or be gone
+func (cache *CachingScanner) ReportFailure(serialNr string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.rescanRequested = true
+}
+
+// Entries returns a snapshot of the cache
+func (cache *CachingScanner) Entries() map[string]CacheEntry {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entries := map[string]CacheEntry{}
+	for serialNr, entry := range cache.entries {
+		entries[serialNr] = *entry
+	}
+	return entries
+}
+
+func (cache *CachingScanner) seen(serialNr string, sensor Sensor, now time.Time) {
+	if cache.entries == nil {
+		cache.entries = map[string]*CacheEntry{}
+	}
+
+	entry, ok := cache.entries[serialNr]
+	if !ok {
+		entry = &CacheEntry{FirstSeen: now}
+		cache.entries[serialNr] = entry
+	}
+	// the configured address wins over the one heard
+	if !entry.Pinned {
+		entry.Sensor = sensor
+	}
+	entry.LastSeen = now
+}
+
+func (cache *CachingScanner) expire(now time.Time) {
+	if cache.Expiry <= 0 {
+		return
+	}
+	for serialNr, entry := range cache.entries {
+		if !entry.Pinned && now.Sub(entry.LastSeen) >= cache.Expiry {
+			delete(cache.entries, serialNr)
+		}
+	}
+}
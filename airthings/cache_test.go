@@ -0,0 +1,81 @@
+package airthings
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testSensor struct {
+	addr string
+}
+
+func (sensor testSensor) Address() string {
+	return sensor.addr
+}
+
+func (sensor testSensor) Receive() (Reading, error) {
+	return sensor.ReceiveContext(context.Background())
+}
+
+func (sensor testSensor) ReceiveContext(ctx context.Context) (Reading, error) {
+	return Reading{Address: sensor.addr, Time: time.Now()}, nil
+}
+
+// finds the given sensors, counting the scans
+type testScanner struct {
+	found map[string]Sensor
+	scans int
+}
+
+func (scanner *testScanner) Scan() (map[string]Sensor, error) {
+	return scanner.ScanContext(context.Background())
+}
+
+func (scanner *testScanner) ScanContext(ctx context.Context) (map[string]Sensor, error) {
+	scanner.scans++
+	return scanner.found, nil
+}
+
+func TestCachingScannerScansDespitePins(t *testing.T) {
+	scanner := &testScanner{found: map[string]Sensor{"2930000002": testSensor{addr: "00:11:22:33:44:02"}}}
+	cache := NewCachingScanner(scanner, time.Hour, 0)
+	cache.Pin("2930000001", testSensor{addr: "00:11:22:33:44:01"})
+
+	sensors, err := cache.ScanContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if scanner.scans != 1 {
+		t.Errorf("expected the first pass to scan, got %d scans", scanner.scans)
+	}
+	if len(sensors) != 2 {
+		t.Errorf("expected both the pinned and the found sensor, got %v", sensors)
+	}
+}
+
+func TestCachingScannerKeepsPinnedSensors(t *testing.T) {
+	pinned := testSensor{addr: "00:11:22:33:44:01"}
+	scanner := &testScanner{found: map[string]Sensor{"2930000001": testSensor{addr: "00:11:22:33:44:99"}}}
+	cache := NewCachingScanner(scanner, time.Hour, 0)
+	cache.Pin("2930000001", pinned)
+	pinnedAt := cache.Entries()["2930000001"].LastSeen
+
+	sensors, err := cache.ScanContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if addr := sensors["2930000001"].Address(); addr != pinned.addr {
+		t.Errorf("expected the configured address %s, got %s", pinned.addr, addr)
+	}
+	if entry := cache.Entries()["2930000001"]; entry.LastSeen.Before(pinnedAt) {
+		t.Errorf("expected the scan to refresh LastSeen")
+	}
+
+	// re-pinning, e.g. after the configured address changed, does replace the sensor
+	repinned := testSensor{addr: "00:11:22:33:44:02"}
+	cache.Pin("2930000001", repinned)
+	if addr := cache.Entries()["2930000001"].Sensor.Address(); addr != repinned.addr {
+		t.Errorf("expected the new configured address %s, got %s", repinned.addr, addr)
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// SensorLabels is the metadata of a sensor, added as labels to every series of it
+type SensorLabels struct {
+	// falls back to the serial number
+	Name     string `yaml:"name" json:"name,omitempty"`
+	Room     string `yaml:"room" json:"room,omitempty"`
+	Floor    string `yaml:"floor" json:"floor,omitempty"`
+	Building string `yaml:"building" json:"building,omitempty"`
+
+	// arbitrary extra labels
+	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
+}
+
+// labels every sensor series has, before the extra ones
+var SensorLabelNames = []string{SerialNumberLabel, "name", "room", "floor", "building"}
+
+// labels some metrics set themselves, on top of SensorLabelNames
+var reservedLabelNames = []string{"model", "firmware", "address", "index", "class", "phase", "le", "quantile"}
+
+// ValidateLabelName checks that an extra label is usable, and does not clash with the ones set by the metrics
+func ValidateLabelName(name string) error {
+	if !model.LabelName(name).IsValid() {
+		return errors.Errorf("%q is not a valid label name", name)
+	}
+	for _, names := range [][]string{SensorLabelNames, reservedLabelNames} {
+		for _, reserved := range names {
+			if name == reserved {
+				return errors.Errorf("%q is a reserved label name", name)
+			}
+		}
+	}
+	return nil
+}
+
+// LabelNames returns SensorLabelNames followed by the extra labels of all the sensors, sorted.
+// The series of every sensor have them all, so that they can be aggregated; missing ones are empty.
+func LabelNames(sensors []SensorLabels) []string {
+	extra := map[string]bool{}
+	for _, sensor := range sensors {
+		for name := range sensor.Labels {
+			extra[name] = true
+		}
+	}
+
+	var extraNames []string
+	for name := range extra {
+		extraNames = append(extraNames, name)
+	}
+	sort.Strings(extraNames)
+
+	return append(append([]string{}, SensorLabelNames...), extraNames...)
+}
+
+// LabelValues returns the values of the labels as named by LabelNames
+func (l SensorLabels) LabelValues(serialNr string, names []string) []string {
+	name := l.Name
+	if name == "" {
+		name = serialNr
+	}
+
+	values := []string{serialNr, name, l.Room, l.Floor, l.Building}
+	for _, extra := range names[len(SensorLabelNames):] {
+		values = append(values, l.Labels[extra])
+	}
+	return values
+}
@@ -0,0 +1,80 @@
+// Package metrics names the measurements the way the exporter publishes them,
+// so that everything producing Prometheus data from readings agrees on it.
+package metrics
+
+import (
+	"github.com/alepar/airthings/airthings"
+)
+
+// label identifying the sensor on every measurement
+const SerialNumberLabel = "serial_number"
+
+// Measurement is one gauge published per sensor
+type Measurement struct {
+	Name string
+	Help string
+
+	// only published for readings where these fields are valid
+	Field airthings.Field
+
+	Value func(values airthings.SensorValues) float64
+}
+
+var Measurements = []Measurement{
+	{
+		Name:  "air_humidity",
+		Help:  "Humidity (units: % of relative Humidity)",
+		Field: airthings.FieldHumidity,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.Humidity) },
+	},
+	{
+		Name:  "air_radon_short",
+		Help:  "Radon Short Term estimate (units: Bq/m3)",
+		Field: airthings.FieldRadonShort,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.RadonShort) },
+	},
+	{
+		Name:  "air_radon_long",
+		Help:  "Radon Long Term estimate (units: Bq/m3)",
+		Field: airthings.FieldRadonLong,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.RadonLong) },
+	},
+	{
+		Name:  "air_temperature",
+		Help:  "Air Temperature (units: degrees Celsius)",
+		Field: airthings.FieldTemperature,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.Temperature) },
+	},
+	{
+		Name:  "air_atm_pressure",
+		Help:  "Atmospheric Pressure (units: hPa)",
+		Field: airthings.FieldAtmPressure,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.AtmPressure) },
+	},
+	{
+		Name:  "air_co2_level",
+		Help:  "Air Carbon Dioxide level (units: ppm)",
+		Field: airthings.FieldCo2Level,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.Co2Level) },
+	},
+	{
+		Name:  "air_voc_level",
+		Help:  "Air Volatile Organic Compounds level (units: ppb)",
+		Field: airthings.FieldVocLevel,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.VocLevel) },
+	},
+	{
+		Name:  "air_battery_voltage",
+		Help:  "Battery voltage (units: V)",
+		Field: airthings.FieldBatteryVoltage,
+		Value: func(values airthings.SensorValues) float64 { return float64(values.BatteryVoltage) },
+	},
+	{
+		Name:  "air_battery_percent",
+		Help:  "Estimated battery level (units: %)",
+		Field: airthings.FieldBatteryVoltage,
+		Value: func(values airthings.SensorValues) float64 {
+			return float64(airthings.BatteryPercent(values.BatteryVoltage))
+		},
+	},
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+// LabelledReading is a reading along with the labels its sensor had at the time
+type LabelledReading struct {
+	airthings.Reading
+	Labels SensorLabels
+}
+
+// WriteOpenMetrics writes the readings in the OpenMetrics text format, every sample with its reading time,
+// as accepted by `promtool tsdb create-blocks-from openmetrics`. The series are named and labelled like
+// the exporter publishes them. Every series is written in one go, oldest sample first;
+// of the readings of a series in the same millisecond, only the first is written.
+func WriteOpenMetrics(w io.Writer, readings []LabelledReading) error {
+	var sensors []SensorLabels
+	for _, reading := range readings {
+		sensors = append(sensors, reading.Labels)
+	}
+	names := LabelNames(sensors)
+
+	// sorting on the labels keeps every series together
+	type sample struct {
+		labels  string
+		reading airthings.Reading
+		// units: ms since epoch, the precision Prometheus keeps
+		timestamp int64
+	}
+	samples := make([]sample, 0, len(readings))
+	for _, reading := range readings {
+		samples = append(samples, sample{
+			labels:    formatLabels(names, reading.Labels.LabelValues(reading.SerialNumber, names)),
+			reading:   reading.Reading,
+			timestamp: reading.Time.UnixNano() / 1e6,
+		})
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		if samples[i].labels != samples[j].labels {
+			return samples[i].labels < samples[j].labels
+		}
+		return samples[i].timestamp < samples[j].timestamp
+	})
+
+	out := bufio.NewWriter(w)
+	for _, m := range Measurements {
+		wroteHeader := false
+		var last *sample
+		for i := range samples {
+			s := &samples[i]
+			if !s.reading.Has(m.Field) {
+				continue
+			}
+			if last != nil && last.labels == s.labels && last.timestamp == s.timestamp {
+				continue
+			}
+			last = s
+
+			if !wroteHeader {
+				fmt.Fprintf(out, "# HELP %s %s\n", m.Name, escape(m.Help))
+				fmt.Fprintf(out, "# TYPE %s gauge\n", m.Name)
+				wroteHeader = true
+			}
+			fmt.Fprintf(out, "%s{%s} %s %s\n",
+				m.Name,
+				s.labels,
+				strconv.FormatFloat(m.Value(s.reading.SensorValues), 'g', -1, 64),
+				strconv.FormatFloat(float64(s.timestamp)/1e3, 'f', -1, 64),
+			)
+		}
+	}
+	fmt.Fprint(out, "# EOF\n")
+	return out.Flush()
+}
+
+// leaves the empty labels out, as Prometheus treats them as missing anyway
+func formatLabels(names []string, values []string) string {
+	var pairs []string
+	for i, name := range names {
+		if values[i] != "" {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escape(values[i])))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// OpenMetrics escapes help texts and label values alike
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
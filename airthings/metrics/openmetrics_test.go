@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	reading := func(serialNr string, time time.Time, values airthings.SensorValues, labels SensorLabels) LabelledReading {
+		return LabelledReading{
+			Reading: airthings.Reading{SerialNumber: serialNr, Time: time, SensorValues: values},
+			Labels:  labels,
+		}
+	}
+	warm := airthings.SensorValues{Temperature: 21.5, RadonShort: 60, Fields: airthings.FieldTemperature | airthings.FieldRadonShort}
+	warmingUp := airthings.SensorValues{Temperature: 21.25, Fields: airthings.FieldTemperature}
+	bedroom := SensorLabels{Name: "kid's \"bed\"room", Room: `up\stairs`, Labels: map[string]string{"zone": "north"}}
+
+	readings := []LabelledReading{
+		// out of order
+		reading("2930000001", at("2021-03-01T12:05:00.250Z"), warm, bedroom),
+		reading("2930000001", at("2021-03-01T12:00:00Z"), warmingUp, bedroom),
+		// same millisecond as the first one, written once
+		reading("2930000001", at("2021-03-01T12:05:00.250400Z"), warm, bedroom),
+		reading("2930000002", at("2021-03-01T12:02:00Z"), warm, SensorLabels{}),
+		// renamed since, a series of its own
+		reading("2930000002", at("2021-03-01T12:07:00Z"), warm, SensorLabels{Name: "office"}),
+	}
+
+	var out bytes.Buffer
+	if err := WriteOpenMetrics(&out, readings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := `# HELP air_radon_short Radon Short Term estimate (units: Bq/m3)
+# TYPE air_radon_short gauge
+air_radon_short{serial_number="2930000001",name="kid's \"bed\"room",room="up\\stairs",zone="north"} 60 1614600300.25
+air_radon_short{serial_number="2930000002",name="2930000002"} 60 1614600120
+air_radon_short{serial_number="2930000002",name="office"} 60 1614600420
+# HELP air_temperature Air Temperature (units: degrees Celsius)
+# TYPE air_temperature gauge
+air_temperature{serial_number="2930000001",name="kid's \"bed\"room",room="up\\stairs",zone="north"} 21.25 1614600000
+air_temperature{serial_number="2930000001",name="kid's \"bed\"room",room="up\\stairs",zone="north"} 21.5 1614600300.25
+air_temperature{serial_number="2930000002",name="2930000002"} 21.5 1614600120
+air_temperature{serial_number="2930000002",name="office"} 21.5 1614600420
+# EOF
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+	checkOpenMetrics(t, out.String())
+}
+
+func TestWriteOpenMetricsEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteOpenMetrics(&out, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "# EOF\n" {
+		t.Errorf("expected only the EOF marker, got %q", out.String())
+	}
+}
+
+var (
+	metricName = `[a-zA-Z_:][a-zA-Z0-9_:]*`
+	labelValue = `"(?:[^"\\\n]|\\[\\"n])*"`
+	labelPair  = `[a-zA-Z_][a-zA-Z0-9_]*=` + labelValue
+	helpLine   = regexp.MustCompile(`^# HELP (` + metricName + `) (?:[^\\\n]|\\[\\"n])*$`)
+	typeLine   = regexp.MustCompile(`^# TYPE (` + metricName + `) (counter|gauge|histogram|gaugehistogram|stateset|info|summary|unknown)$`)
+	sampleLine = regexp.MustCompile(`^(` + metricName + `)(\{(?:` + labelPair + `(?:,` + labelPair + `)*)?\}) (\S+) (\S+)$`)
+	labelName  = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)=` + labelValue)
+)
+
+// checks the rules of the OpenMetrics text format that promtool enforces when creating blocks:
+// well formed lines, every family declared once before its samples and never interleaved with another,
+// timestamps on every sample and ascending within every series, and # EOF last
+func checkOpenMetrics(t *testing.T, text string) {
+	t.Helper()
+
+	if !strings.HasSuffix(text, "# EOF\n") {
+		t.Fatalf("expected # EOF last, got %q", text)
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "# EOF\n"), "\n")
+	lines = lines[:len(lines)-1]
+
+	families := map[string]bool{}
+	family := ""
+	lastTimestamp := map[string]float64{}
+	for i, line := range lines {
+		if m := helpLine.FindStringSubmatch(line); m != nil {
+			if families[m[1]] {
+				t.Errorf("line %d: %s declared again", i+1, m[1])
+			}
+			family = m[1]
+			families[family] = true
+			continue
+		}
+		if m := typeLine.FindStringSubmatch(line); m != nil {
+			if m[1] != family {
+				t.Errorf("line %d: TYPE of %s within the family %s", i+1, m[1], family)
+			}
+			continue
+		}
+
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line %d: malformed %q", i+1, line)
+			continue
+		}
+		name, labels, value, timestamp := m[1], m[2], m[3], m[4]
+		if name != family {
+			t.Errorf("line %d: sample of %s within the family %s", i+1, name, family)
+		}
+		seen := map[string]bool{}
+		for _, pair := range labelName.FindAllStringSubmatch(labels, -1) {
+			if seen[pair[1]] {
+				t.Errorf("line %d: label %s repeated", i+1, pair[1])
+			}
+			seen[pair[1]] = true
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			t.Errorf("line %d: invalid value %q", i+1, value)
+		}
+		ts, err := strconv.ParseFloat(timestamp, 64)
+		if err != nil {
+			t.Errorf("line %d: invalid timestamp %q", i+1, timestamp)
+		}
+		series := name + labels
+		if last, ok := lastTimestamp[series]; ok && ts <= last {
+			t.Errorf("line %d: timestamp %s not after %g in %s", i+1, timestamp, last, series)
+		}
+		lastTimestamp[series] = ts
+	}
+}
@@ -0,0 +1,197 @@
+package airthings
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// PollPlanner decides when each sensor is due for a read. Sensors only refresh their values
+// every RefreshInterval, so rather than polling blindly, it watches for the values to change,
+// narrows down when in the cycle the sensor refreshes, and times the next read just after the next refresh.
+type PollPlanner struct {
+	// how often the sensors refresh their values, Wave Plus does so every 5 minutes
+	RefreshInterval time.Duration
+
+	// how long after the expected refresh to read
+	Delay time.Duration
+
+	// up to this much is randomly added to every read time, so that sensors do not stay in lockstep
+	Jitter time.Duration
+
+	// how soon to read again when the values did not change yet, zero means RefreshInterval/5
+	Step time.Duration
+
+	// used until the refresh cycle of a sensor is known, and after failed reads
+	FallbackInterval time.Duration
+
+	// fixed read intervals per serial number, bypassing the refresh detection
+	Overrides map[string]time.Duration
+
+	mu     sync.Mutex
+	states map[string]*pollState
+}
+
+// the sensor clock is assumed to drift by less than 1/maxDrift of a cycle per cycle, far more than it should
+const maxDrift = 100
+
+type pollState struct {
+	values     SensorValues
+	lastRead   time.Time
+	lastChange time.Time
+
+	// a refresh happened after refreshAfter and no later than refreshBy, zero until a change was seen
+	refreshAfter time.Time
+	refreshBy    time.Time
+
+	next time.Time
+}
+
+// Due returns true if the sensor should be read now; sensors never seen before are due right away
+func (planner *PollPlanner) Due(serialNr string, now time.Time) bool {
+	return !planner.Next(serialNr).After(now)
+}
+
+// Next returns when the sensor is due for a read
+func (planner *PollPlanner) Next(serialNr string) time.Time {
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+
+	if state, ok := planner.states[serialNr]; ok {
+		return state.next
+	}
+	return time.Time{}
+}
+
+// Observe plans the next read of the sensor after a successful one
+func (planner *PollPlanner) Observe(reading Reading) {
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+
+	state := planner.state(reading.SerialNumber)
+	values := refreshed(reading.SensorValues)
+	first := state.lastRead.IsZero()
+	changed := !first && values != state.values
+	if changed {
+		planner.refreshedBetween(state, state.lastRead, reading.Time)
+	}
+	if first || changed {
+		state.lastChange = reading.Time
+	}
+	state.values = values
+	state.lastRead = reading.Time
+
+	switch {
+	case planner.Overrides[reading.SerialNumber] > 0:
+		state.next = reading.Time.Add(planner.Overrides[reading.SerialNumber])
+	case changed && state.refreshBy.Sub(state.refreshAfter) > 2*planner.step():
+		// the refresh time is not known precisely enough yet, e.g. after a stable spell read at FallbackInterval:
+		// either this read sees the refresh, or the ones stepping after it narrow it down
+		state.next = planner.nextCycle(state.refreshAfter, reading.Time).Add(planner.step())
+	case changed:
+		state.next = planner.nextCycle(state.refreshBy, reading.Time).Add(planner.Delay)
+	case reading.Time.Sub(state.lastChange) > 2*planner.RefreshInterval:
+		// values stay the same for longer than the refresh cycle, e.g. in a very stable environment
+		state.next = reading.Time.Add(planner.FallbackInterval)
+	default:
+		state.next = reading.Time.Add(planner.step())
+	}
+	state.next = state.next.Add(planner.jitter())
+}
+
+// Failed plans the next read of the sensor after a failed one
+func (planner *PollPlanner) Failed(serialNr string, now time.Time) {
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+
+	interval := planner.FallbackInterval
+	if override := planner.Overrides[serialNr]; override > 0 {
+		interval = override
+	}
+	planner.state(serialNr).next = now.Add(interval + planner.jitter())
+}
+
+// Forget drops what is known about the sensor, making it due right away
+func (planner *PollPlanner) Forget(serialNr string) {
+	planner.mu.Lock()
+	defer planner.mu.Unlock()
+	delete(planner.states, serialNr)
+}
+
+func (planner *PollPlanner) state(serialNr string) *pollState {
+	if planner.states == nil {
+		planner.states = map[string]*pollState{}
+	}
+	state, ok := planner.states[serialNr]
+	if !ok {
+		state = &pollState{}
+		planner.states[serialNr] = state
+	}
+	return state
+}
+
+// narrows down when the sensor refreshes, knowing it did between the two reads
+func (planner *PollPlanner) refreshedBetween(state *pollState, after time.Time, by time.Time) {
+	cycle := planner.RefreshInterval
+	if !state.refreshBy.IsZero() && cycle > 0 {
+		// the refresh time known from the previous cycles, moved to the latest cycle ending by now,
+		// or the one after it, which may still have started before now; widened as the sensor clock drifts
+		n := by.Sub(state.refreshBy) / cycle
+		for _, k := range []time.Duration{n, n + 1} {
+			drift := k * cycle / maxDrift
+			from := latest(state.refreshAfter.Add(k*cycle-drift), after)
+			to := earliest(state.refreshBy.Add(k*cycle+drift), by)
+			if from.Before(to) {
+				state.refreshAfter, state.refreshBy = from, to
+				return
+			}
+		}
+	}
+
+	// first change seen, or the sensor drifted off the known cycle
+	state.refreshAfter, state.refreshBy = after, by
+}
+
+// the first time after now that is a whole number of refresh cycles away from t
+func (planner *PollPlanner) nextCycle(t time.Time, now time.Time) time.Time {
+	cycle := planner.RefreshInterval
+	if cycle <= 0 {
+		return now
+	}
+	return t.Add((now.Sub(t)/cycle + 1) * cycle)
+}
+
+func latest(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func (planner *PollPlanner) step() time.Duration {
+	if planner.Step > 0 {
+		return planner.Step
+	}
+	return planner.RefreshInterval / 5
+}
+
+func (planner *PollPlanner) jitter() time.Duration {
+	if planner.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(planner.Jitter)))
+}
+
+// leaves out what the sensor reports live rather than on the refresh cycle
+func refreshed(values SensorValues) SensorValues {
+	values.BatteryVoltage = 0
+	values.Fields &^= FieldBatteryVoltage
+	return values
+}
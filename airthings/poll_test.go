@@ -0,0 +1,95 @@
+package airthings
+
+import (
+	"testing"
+	"time"
+)
+
+// a sensor that refreshes every period, starting at the given time, reporting the number of refreshes so far
+type refreshingSensor struct {
+	start  time.Time
+	period time.Duration
+}
+
+func (sensor refreshingSensor) cycle(t time.Time) int {
+	return int(t.Sub(sensor.start) / sensor.period)
+}
+
+func (sensor refreshingSensor) read(t time.Time) Reading {
+	return Reading{
+		SerialNumber: "2930000001",
+		Time:         t,
+		SensorValues: SensorValues{RadonShort: uint16(sensor.cycle(t)), Fields: FieldRadonShort},
+	}
+}
+
+// reads whenever the planner says so, returning the cycles seen and the lag of the reads that saw a refresh
+func simulate(planner *PollPlanner, sensor refreshingSensor, from time.Time, reads int) (cycles []int, lags []time.Duration) {
+	const latency = 2 * time.Second
+
+	t := from
+	for i := 0; i < reads; i++ {
+		reading := sensor.read(t)
+		planner.Observe(reading)
+
+		cycle := sensor.cycle(t)
+		if len(cycles) == 0 || cycles[len(cycles)-1] != cycle {
+			cycles = append(cycles, cycle)
+			lags = append(lags, t.Sub(sensor.start.Add(time.Duration(cycle)*sensor.period)))
+		}
+		t = planner.Next(reading.SerialNumber).Add(latency)
+	}
+	return cycles, lags
+}
+
+func TestPollPlannerReadsEveryRefresh(t *testing.T) {
+	for _, period := range []time.Duration{300 * time.Second, 301 * time.Second, 299 * time.Second} {
+		t.Run(period.String(), func(t *testing.T) {
+			planner := &PollPlanner{
+				RefreshInterval:  300 * time.Second,
+				Delay:            15 * time.Second,
+				Jitter:           10 * time.Second,
+				FallbackInterval: 150 * time.Second,
+			}
+			start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			sensor := refreshingSensor{start: start, period: period}
+
+			cycles, lags := simulate(planner, sensor, start.Add(123*time.Second), 200)
+
+			for i := 1; i < len(cycles); i++ {
+				if cycles[i] != cycles[i-1]+1 {
+					t.Fatalf("refreshes %d to %d were never read", cycles[i-1]+1, cycles[i]-1)
+				}
+			}
+			// a few reads go to finding the cycle, and a few more to the sensor drifting off it
+			if len(cycles) < 150 {
+				t.Errorf("expected about one read per refresh, got %d refreshes in 200 reads", len(cycles))
+			}
+			for i, lag := range lags[10:] {
+				if lag > planner.RefreshInterval/2 {
+					t.Errorf("expected to read soon after the refresh, read refresh %d after %s", cycles[i+10], lag)
+				}
+			}
+		})
+	}
+}
+
+func TestPollPlannerOverrides(t *testing.T) {
+	planner := &PollPlanner{
+		RefreshInterval:  300 * time.Second,
+		Delay:            15 * time.Second,
+		FallbackInterval: 150 * time.Second,
+		Overrides:        map[string]time.Duration{"2930000001": time.Hour},
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sensor := refreshingSensor{start: now, period: 300 * time.Second}
+
+	planner.Observe(sensor.read(now))
+	if next := planner.Next("2930000001"); !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the next read in an hour, got %s", next.Sub(now))
+	}
+	planner.Failed("2930000001", now)
+	if next := planner.Next("2930000001"); !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the next read in an hour after a failure, got %s", next.Sub(now))
+	}
+}
@@ -1,7 +1,12 @@
 package airthings
 
+import "context"
+
 type Scanner interface {
 
 	// returns map from SerialNumber to sensor struct
 	Scan() (map[string]Sensor, error)
+
+	// same as Scan, but gives up as soon as ctx is done
+	ScanContext(ctx context.Context) (map[string]Sensor, error)
 }
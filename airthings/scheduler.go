@@ -0,0 +1,156 @@
+package airthings
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReadScheduler reads sensors concurrently, so that one slow or failing sensor does not hold up the others
+type ReadScheduler struct {
+	// max reads in flight per group, not counting the ones backing off between retries; zero means 1
+	Concurrency int
+
+	// deadline for reading one sensor, retries included; zero means none
+	Timeout time.Duration
+
+	// splits sensors into groups with their own Concurrency limit, e.g. one per BLE adapter;
+	// nil means all the sensors share one group
+	Group func(serialNr string, sensor Sensor) string
+
+	mu sync.Mutex
+	// for fairness, the sensors that waited the longest are read first
+	lastStarted map[string]time.Time
+}
+
+// ReadResult is the outcome of reading one sensor
+type ReadResult struct {
+	SerialNumber string
+	Reading      Reading
+	Err          error
+}
+
+// ReadAll reads every sensor, and returns once all of them are done.
+// handle is called as soon as a read completes, from one goroutine at a time.
+func (scheduler *ReadScheduler) ReadAll(ctx context.Context, sensors map[string]Sensor, handle func(ReadResult)) {
+	groups := map[string][]string{}
+	for _, serialNr := range scheduler.fairOrder(sensors) {
+		group := ""
+		if scheduler.Group != nil {
+			group = scheduler.Group(serialNr, sensors[serialNr])
+		}
+		groups[group] = append(groups[group], serialNr)
+	}
+
+	concurrency := scheduler.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var handleMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, serialNrs := range groups {
+		wg.Add(1)
+		go func(serialNrs []string) {
+			defer wg.Done()
+
+			// reads hand their slot back while idle, see WhileIdle
+			slots := make(chan struct{}, concurrency)
+			var reads sync.WaitGroup
+			for _, serialNr := range serialNrs {
+				slots <- struct{}{}
+				reads.Add(1)
+				go func(serialNr string) {
+					defer reads.Done()
+					slot := &readSlot{slots: slots, held: true}
+					result := scheduler.read(context.WithValue(ctx, readSlotKey{}, slot), serialNr, sensors[serialNr])
+					slot.release()
+
+					handleMu.Lock()
+					handle(result)
+					handleMu.Unlock()
+				}(serialNr)
+			}
+			reads.Wait()
+		}(serialNrs)
+	}
+	wg.Wait()
+}
+
+// WhileIdle runs fn, e.g. the sleep before a retry, with the ReadScheduler slot of the read ctx belongs to
+// handed back, so that other sensors are read meanwhile rather than waiting for the failing one.
+// Returns the error of fn, or ctx.Err() if ctx is done before the slot is taken back.
+func WhileIdle(ctx context.Context, fn func() error) error {
+	slot, ok := ctx.Value(readSlotKey{}).(*readSlot)
+	if !ok || !slot.held {
+		return fn()
+	}
+
+	slot.release()
+	err := fn()
+	select {
+	case slot.slots <- struct{}{}:
+		slot.held = true
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+type readSlotKey struct{}
+
+// one of the concurrency slots of a group, only used by the goroutine reading the sensor
+type readSlot struct {
+	slots chan struct{}
+	held  bool
+}
+
+func (slot *readSlot) release() {
+	if slot.held {
+		<-slot.slots
+		slot.held = false
+	}
+}
+
+func (scheduler *ReadScheduler) read(ctx context.Context, serialNr string, sensor Sensor) ReadResult {
+	scheduler.mu.Lock()
+	if scheduler.lastStarted == nil {
+		scheduler.lastStarted = map[string]time.Time{}
+	}
+	scheduler.lastStarted[serialNr] = time.Now()
+	scheduler.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return ReadResult{SerialNumber: serialNr, Err: ctx.Err()}
+	}
+	if scheduler.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scheduler.Timeout)
+		defer cancel()
+	}
+
+	reading, err := sensor.ReceiveContext(ctx)
+	return ReadResult{SerialNumber: serialNr, Reading: reading, Err: err}
+}
+
+// never read sensors first, then the ones read the longest ago
+func (scheduler *ReadScheduler) fairOrder(sensors map[string]Sensor) []string {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+
+	serialNrs := make([]string, 0, len(sensors))
+	for serialNr := range sensors {
+		serialNrs = append(serialNrs, serialNr)
+	}
+	sort.Slice(serialNrs, func(i, j int) bool {
+		ti, tj := scheduler.lastStarted[serialNrs[i]], scheduler.lastStarted[serialNrs[j]]
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return serialNrs[i] < serialNrs[j]
+	})
+	return serialNrs
+}
@@ -0,0 +1,52 @@
+package airthings
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fails every attempt, backing off between them like RetryPolicy does
+type failingSensor struct {
+	testSensor
+	attempts int
+	backoff  time.Duration
+}
+
+func (sensor failingSensor) ReceiveContext(ctx context.Context) (Reading, error) {
+	for attempt := 1; attempt < sensor.attempts; attempt++ {
+		err := WhileIdle(ctx, func() error {
+			select {
+			case <-time.After(sensor.backoff):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err != nil {
+			return Reading{}, err
+		}
+	}
+	return Reading{}, errors.New("out of range")
+}
+
+func TestReadSchedulerReadsOthersWhileBackingOff(t *testing.T) {
+	scheduler := &ReadScheduler{Concurrency: 1}
+	sensors := map[string]Sensor{
+		// read first, as the lower serial number
+		"2930000001": failingSensor{attempts: 3, backoff: 100 * time.Millisecond},
+		"2930000002": testSensor{addr: "00:11:22:33:44:02"},
+		"2930000003": testSensor{addr: "00:11:22:33:44:03"},
+	}
+
+	var order []string
+	scheduler.ReadAll(context.Background(), sensors, func(result ReadResult) {
+		order = append(order, result.SerialNumber)
+	})
+
+	if len(order) != 3 || order[2] != "2930000001" {
+		t.Errorf("expected the failing sensor to complete last, got %v", order)
+	}
+}
@@ -1,8 +1,42 @@
 package airthings
 
+import (
+	"context"
+	"time"
+)
+
 type Sensor interface {
 	Address() string
-	Receive() (SensorValues, error)
+	Receive() (Reading, error)
+
+	// same as Receive, but gives up as soon as ctx is done
+	ReceiveContext(ctx context.Context) (Reading, error)
+}
+
+// Reading is one acquisition of SensorValues along with where and how it was obtained
+type Reading struct {
+	SensorValues
+
+	SerialNumber string
+	Address      string
+
+	// when the values were read from the device
+	Time time.Time
+
+	// signal strength of the connection, units: dBm
+	RSSI int
+
+	// time it took to connect and read the values
+	Latency time.Duration
+
+	// characteristic values as read from the device, before decoding
+	Raw [][]byte
+
+	// nil if the device information was not read yet
+	Info *DeviceInfo
+
+	// values the model reports without us knowing what they mean
+	RawFields []RawField
 }
 
 type SensorValues struct {
@@ -26,4 +60,44 @@ type SensorValues struct {
 
 	// units: ppb
 	VocLevel float32
+
+	// units: V
+	BatteryVoltage float32
+
+	// which of the fields above hold a valid measurement, the rest are zero:
+	// either the model does not measure them, or the sensor reported them as unavailable (e.g. while warming up)
+	Fields Field
+}
+
+// Has returns true if all the given fields hold a valid measurement
+func (values SensorValues) Has(fields Field) bool {
+	return values.Fields&fields == fields
+}
+
+// Field is a bitmask of SensorValues measurement fields
+type Field uint16
+
+const (
+	FieldHumidity Field = 1 << iota
+	FieldRadonShort
+	FieldRadonLong
+	FieldTemperature
+	FieldAtmPressure
+	FieldCo2Level
+	FieldVocLevel
+	FieldBatteryVoltage
+)
+
+// RawField is an undecoded value, identified by its index in the model frame layout
+type RawField struct {
+	Index int
+	Value uint16
+}
+
+// DeviceInfo is what the device reports about itself, fields it does not report are empty
+type DeviceInfo struct {
+	Manufacturer string
+	Model        string
+	Firmware     string
+	Hardware     string
 }
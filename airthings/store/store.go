@@ -0,0 +1,79 @@
+// Package store keeps readings in a local file, one JSON object per line,
+// so that they can be replayed later, e.g. to backfill Prometheus after it missed some scrapes.
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/metrics"
+)
+
+// Record is one line of the file: a reading, along with the labels its sensor had when it was read
+type Record struct {
+	Time         time.Time `json:"time"`
+	SerialNumber string    `json:"serial_number"`
+
+	// name, room, floor, building and extra labels
+	metrics.SensorLabels
+
+	Address string                 `json:"address"`
+	Values  airthings.SensorValues `json:"values"`
+}
+
+func NewRecord(reading airthings.Reading, labels metrics.SensorLabels) Record {
+	return Record{
+		Time:         reading.Time,
+		SerialNumber: reading.SerialNumber,
+		SensorLabels: labels,
+		Address:      reading.Address,
+		Values:       reading.SensorValues,
+	}
+}
+
+// Reading returns what was recorded of the reading
+func (r Record) Reading() airthings.Reading {
+	return airthings.Reading{
+		SensorValues: r.Values,
+		SerialNumber: r.SerialNumber,
+		Address:      r.Address,
+		Time:         r.Time,
+	}
+}
+
+// Writer appends records to a file
+type Writer struct {
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+// Write writes the record as one line
+func (w *Writer) Write(record Record) error {
+	return errors.Wrap(w.enc.Encode(record), "failed to write record")
+}
+
+// Read passes every record to handle, in the order they were written, until handle returns an error
+func Read(r io.Reader, handle func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return errors.Wrapf(err, "invalid record on line %d", line)
+		}
+		if err := handle(record); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "failed to read records")
+}
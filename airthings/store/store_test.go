@@ -0,0 +1,68 @@
+package store
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/metrics"
+)
+
+func TestWriteRead(t *testing.T) {
+	reading := airthings.Reading{
+		SerialNumber: "2930123456",
+		Address:      "00:11:22:33:44:55",
+		Time:         time.Date(2021, 3, 1, 12, 0, 0, 250e6, time.UTC),
+		SensorValues: airthings.SensorValues{Temperature: 21.5, RadonShort: 60, Fields: airthings.FieldTemperature | airthings.FieldRadonShort},
+	}
+	labels := metrics.SensorLabels{Name: "bedroom", Floor: "1", Labels: map[string]string{"zone": "north"}}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := 0; i < 2; i++ {
+		if err := w.Write(NewRecord(reading, labels)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	var records []Record
+	err := Read(&buf, func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected both records, got %d", len(records))
+	}
+	if got := records[1].Reading(); !got.Time.Equal(reading.Time) || got.SerialNumber != reading.SerialNumber ||
+		got.Address != reading.Address || got.SensorValues != reading.SensorValues {
+		t.Errorf("expected %+v, got %+v", reading, got)
+	}
+	if !reflect.DeepEqual(records[1].SensorLabels, labels) {
+		t.Errorf("expected labels %+v, got %+v", labels, records[1].SensorLabels)
+	}
+}
+
+func TestReadInvalidLine(t *testing.T) {
+	lines := `{"time":"2021-03-01T12:00:00Z","serial_number":"2930123456","name":"bedroom","room":"upstairs","address":"00:11:22:33:44:55","values":{"Temperature":21.5,"Fields":8}}
+
+{"time":"2021-03-01T12:05:00Z","serial_`
+
+	var records []Record
+	err := Read(strings.NewReader(lines), func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("expected an error on line 3, got %v", err)
+	}
+	// written before floor, building and extra labels were recorded
+	if len(records) != 1 || records[0].Name != "bedroom" || records[0].Room != "upstairs" {
+		t.Errorf("expected the record before the invalid line, got %+v", records)
+	}
+}
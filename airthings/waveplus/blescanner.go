@@ -15,29 +15,47 @@ import (
 type BleScanner struct {
 	ScanDuration time.Duration
 	Retries      int
+
+	// nil means BleTransport, also used by the sensors found
+	Transport Transport
+
+	// nil means Retries attempts, ScanDuration apart; also used by the sensors found
+	RetryPolicy *RetryPolicy
 }
 
 func (scanner *BleScanner) Scan() (map[string]airthings.Sensor, error) {
-	var lastErr error
+	return scanner.ScanContext(context.Background())
+}
+
+func (scanner *BleScanner) ScanContext(ctx context.Context) (map[string]airthings.Sensor, error) {
 	var devices map[string]airthings.Sensor
-	for i := 0; i < scanner.Retries; i++ {
-		devices, lastErr = scanner.scan()
-		if lastErr == nil {
-			return devices, nil
-		}
-		if i < scanner.Retries {
-			log.Errorf("retrying error in scan: %s", lastErr)
-			time.Sleep(scanner.ScanDuration) // self-pacing interval in an attempt to fix freezes
-		}
+	err := scanner.retryPolicy().Do(ctx, "scan", func(ctx context.Context) error {
+		var err error
+		devices, err = scanner.scan(ctx)
+		return err
+	})
+	if err != nil {
+		return map[string]airthings.Sensor{}, err
 	}
+	return devices, nil
+}
 
-	return map[string]airthings.Sensor{}, errors.Wrap(lastErr, "all retries to scan failed")
+func (scanner *BleScanner) retryPolicy() *RetryPolicy {
+	if scanner.RetryPolicy == nil {
+		return fixedRetryPolicy(scanner.Retries, scanner.ScanDuration)
+	}
+	return scanner.RetryPolicy
 }
 
-func (scanner *BleScanner) scan() (map[string]airthings.Sensor, error) {
-	ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), scanner.ScanDuration))
+func (scanner *BleScanner) scan(ctx context.Context) (map[string]airthings.Sensor, error) {
+	ctx = ble.WithSigHandler(context.WithTimeout(ctx, scanner.ScanDuration))
 	log.Debugf("finding the devices")
-	ads, err := ble.Find(ctx, false, wavePlusOnlyFilter)
+	var ads []Advertisement
+	err := scanner.transport().Scan(ctx, func(a Advertisement) {
+		if supportedModelsFilter(a) {
+			ads = append(ads, a)
+		}
+	})
 	log.Debugf("finished finding the devices")
 	if err != nil {
 		switch errors.Cause(err) {
@@ -46,30 +64,50 @@ func (scanner *BleScanner) scan() (map[string]airthings.Sensor, error) {
 		case context.Canceled:
 			return map[string]airthings.Sensor{}, errors.Wrap(err, "scan for devices cancelled")
 		default:
-			return map[string]airthings.Sensor{}, errors.Wrap(err, "failed to scan for devices")
+			return map[string]airthings.Sensor{}, classify(ErrAdapterDown, errors.Wrap(err, "failed to scan for devices"))
 		}
 	}
 
 	sensorMap := map[string]airthings.Sensor{}
 
+	// the same sensor may be heard several times, e.g. by different adapters
+	bestRssi := map[string]int{}
 	for _, a := range ads {
-		addr := a.Addr().String()
-		serialNr := manufacturerDataToSerialNumber(a.ManufacturerData())
-		sensorMap[serialNr] = &BleSensor{
-			Addr:         addr,
-			ScanDuration: scanner.ScanDuration,
-			Retries:      scanner.Retries,
+		addr := a.Address
+		serialNr := manufacturerDataToSerialNumber(a.ManufacturerData)
+		if rssi, seen := bestRssi[serialNr]; seen && rssi >= a.RSSI {
+			continue
 		}
+		bestRssi[serialNr] = a.RSSI
+		model, _ := ModelBySerialNumber(serialNr)
+		sensorMap[serialNr] = scanner.newSensor(serialNr, addr, model)
 	}
 
 	return sensorMap, nil
 }
 
-func wavePlusOnlyFilter(a ble.Advertisement) bool {
-	if a.Connectable() {
-		manufacturerData := a.ManufacturerData()
+func (scanner *BleScanner) newSensor(serialNr string, addr string, model *Model) *BleSensor {
+	return &BleSensor{
+		SerialNumber: serialNr,
+		Addr:         addr,
+		ScanDuration: scanner.ScanDuration,
+		Retries:      scanner.Retries,
+		Model:        model,
+		Transport:    scanner.Transport,
+		RetryPolicy:  scanner.RetryPolicy,
+	}
+}
+
+// accepts Airthings devices of the models we know how to read
+func supportedModelsFilter(a Advertisement) bool {
+	if a.Connectable {
+		manufacturerData := a.ManufacturerData
 		if len(manufacturerData) >= 6 && manufacturerData[1] == 0x03 {
-			return true
+			serialNr := manufacturerDataToSerialNumber(manufacturerData)
+			if _, ok := ModelBySerialNumber(serialNr); ok {
+				return true
+			}
+			log.Debugf("ignoring unsupported device: serialNr %s addr %s", serialNr, a.Address)
 		}
 	}
 
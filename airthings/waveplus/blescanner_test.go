@@ -0,0 +1,67 @@
+package waveplus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/waveplustest"
+)
+
+func TestScanFiltersSupportedModels(t *testing.T) {
+	unsupported := waveplustest.NewWavePlus(1234567890, "00:11:22:33:44:01", testValues)
+	stranger := waveplustest.NewWavePlus(2930000002, "00:11:22:33:44:02", testValues)
+	stranger.ManufacturerData = []byte{0x4c, 0x00, 0x02, 0x15, 0x00, 0x00}
+
+	scanner := &waveplus.BleScanner{
+		ScanDuration: 20 * time.Millisecond,
+		Retries:      1,
+		Transport: waveplustest.NewTransport(
+			waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues),
+			unsupported,
+			stranger,
+		),
+	}
+
+	sensors, err := scanner.ScanContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sensors) != 1 {
+		t.Fatalf("expected only the Wave Plus, got %v", sensors)
+	}
+	sensor, ok := sensors["2930123456"].(*waveplus.BleSensor)
+	if !ok {
+		t.Fatalf("expected the Wave Plus, got %v", sensors)
+	}
+	if sensor.Address() != wavePlusAddr || sensor.Model != waveplus.WavePlus {
+		t.Errorf("expected %s at %s, got %s at %s", waveplus.WavePlus.Name, wavePlusAddr, sensor.Model.Name, sensor.Address())
+	}
+}
+
+func TestScanKeepsBestRssi(t *testing.T) {
+	far := waveplustest.NewWavePlus(wavePlusSerial, "00:11:22:33:44:01", testValues)
+	far.RSSI = -90
+	near := waveplustest.NewWavePlus(wavePlusSerial, "00:11:22:33:44:02", testValues)
+	near.RSSI = -50
+	farther := waveplustest.NewWavePlus(wavePlusSerial, "00:11:22:33:44:03", testValues)
+	farther.RSSI = -95
+
+	scanner := &waveplus.BleScanner{
+		ScanDuration: 20 * time.Millisecond,
+		Retries:      1,
+		Transport:    waveplustest.NewTransport(far, near, farther),
+	}
+
+	sensors, err := scanner.ScanContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sensors) != 1 {
+		t.Fatalf("expected one sensor, got %v", sensors)
+	}
+	if addr := sensors["2930123456"].Address(); addr != near.Address {
+		t.Errorf("expected the address heard best %s, got %s", near.Address, addr)
+	}
+}
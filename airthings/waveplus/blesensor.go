@@ -1,10 +1,8 @@
 package waveplus
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-ble/ble"
@@ -12,145 +10,356 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/waveplus/frame"
+)
+
+const (
+	DefaultScanDuration    = 5 * time.Second
+	DefaultRetries         = 5
+	DefaultBatteryInterval = time.Hour
+
+	// for the sensor to answer a command, on top of the connection
+	commandTimeout = 5 * time.Second
+)
+
+// Phase is a step of reading a sensor, as timed for BleSensor.Observe
+type Phase string
+
+const (
+	PhaseConnect  Phase = "connect"
+	PhaseDiscover Phase = "discover"
+	PhaseRead     Phase = "read"
 )
 
 type BleSensor struct {
+	SerialNumber string
 	Addr         string
 	ScanDuration time.Duration
 	Retries      int
+
+	// nil means WavePlus
+	Model *Model
+
+	// nil means BleTransport
+	Transport Transport
+
+	// nil means Retries attempts, ScanDuration apart
+	RetryPolicy *RetryPolicy
+
+	// how often to read the battery voltage, which drains over months, the reads in between report the last one;
+	// zero means DefaultBatteryInterval
+	BatteryInterval time.Duration
+
+	// called after every connect, discovery and read of the values, retries included, for metrics
+	Observe func(phase Phase, d time.Duration, err error)
+
+	mu sync.Mutex
+	// GATT handles of the model characteristics, discovered on the first read
+	characteristics []*ble.Characteristic
+	// handle of the model command characteristic, discovered on the first battery read
+	command *ble.Characteristic
+	// units: V, valid if batteryReadAt is set
+	battery       float32
+	batteryReadAt time.Time
+	// read on the first connection
+	info *airthings.DeviceInfo
+}
+
+// NewSensor builds a sensor from its known serial number and address, so that it can be read without a scan.
+// The model is derived from the serial number; the rest of the fields can be adjusted before the first read.
+func NewSensor(serialNr string, addr string) *BleSensor {
+	model, _ := ModelBySerialNumber(serialNr)
+	return &BleSensor{
+		SerialNumber: serialNr,
+		Addr:         addr,
+		ScanDuration: DefaultScanDuration,
+		Retries:      DefaultRetries,
+		Model:        model,
+	}
 }
 
 func (sensor *BleSensor) Address() string {
 	return sensor.Addr
 }
 
-func (sensor *BleSensor) Receive() (airthings.SensorValues, error) {
-	var lastErr error
-	var values airthings.SensorValues
-	for i := 0; i < sensor.Retries; i++ {
-		values, lastErr = sensor.receive()
-		if lastErr == nil {
-			return values, nil
+func (sensor *BleSensor) Receive() (airthings.Reading, error) {
+	return sensor.ReceiveContext(context.Background())
+}
+
+func (sensor *BleSensor) ReceiveContext(ctx context.Context) (airthings.Reading, error) {
+	var reading airthings.Reading
+	err := sensor.retryPolicy().Do(ctx, "receive", func(ctx context.Context) error {
+		var err error
+		reading, err = sensor.receive(ctx)
+		return err
+	})
+	if err != nil {
+		return airthings.Reading{}, err
+	}
+	return reading, nil
+}
+
+func (sensor *BleSensor) retryPolicy() *RetryPolicy {
+	if sensor.RetryPolicy == nil {
+		return fixedRetryPolicy(sensor.Retries, sensor.ScanDuration)
+	}
+	return sensor.RetryPolicy
+}
+
+func (sensor *BleSensor) receive(parent context.Context) (airthings.Reading, error) {
+	started := time.Now()
+	ctx := ble.WithSigHandler(context.WithTimeout(parent, sensor.ScanDuration))
+	cln, err := sensor.connect(ctx)
+	if err != nil {
+		return airthings.Reading{}, err
+	}
+	defer func() {
+		log.Debugf("closing connection")
+		_ = cln.Close()
+	}()
+
+	model := sensor.model()
+	frames, err := sensor.readFrames(cln, model)
+	if err != nil {
+		return airthings.Reading{}, err
+	}
+
+	now := time.Now()
+	values, err := model.Decode(frames)
+	if err != nil {
+		// the handles may point elsewhere, e.g. after a firmware update
+		sensor.mu.Lock()
+		sensor.characteristics = nil
+		sensor.info = nil
+		sensor.mu.Unlock()
+
+		err = errors.Wrapf(err, "failed to decode %s values", model.Name)
+		var versionErr *frame.UnsupportedVersionError
+		if errors.As(err, &versionErr) {
+			// reading again is not going to change the firmware
+			err = Permanent(err)
 		}
-		if i < sensor.Retries {
-			log.Errorf("retrying error in receive: %s", lastErr)
-			time.Sleep(sensor.ScanDuration) // self-pacing interval in an attempt to fix freezes
+		return airthings.Reading{}, err
+	}
+
+	var rawFields []airthings.RawField
+	if model.RawFields != nil {
+		rawFields, err = model.RawFields(frames)
+		if err != nil {
+			log.Warnf("failed to extract raw fields of %s: %s", sensor.SerialNumber, err)
 		}
 	}
 
-	return airthings.SensorValues{}, errors.Wrap(lastErr, "all retries to receive failed")
-}
+	if model.Command != nil {
+		// not bounded by the connect timeout, which a slow connect may have used up
+		if voltage, ok := sensor.batteryVoltage(parent, cln, model); ok {
+			values.BatteryVoltage = voltage
+			values.Fields |= airthings.FieldBatteryVoltage
+		}
+	}
 
-func (sensor *BleSensor) receive() (airthings.SensorValues, error) {
-	filter := func(a ble.Advertisement) bool {
-		return strings.ToUpper(a.Addr().String()) == strings.ToUpper(sensor.Addr)
+	// same as the battery, not worth failing the read over
+	info, err := sensor.deviceInfo(cln)
+	if err != nil {
+		log.Warnf("failed to read device information of %s: %s", sensor.SerialNumber, err)
 	}
 
+	return airthings.Reading{
+		SensorValues: values,
+		SerialNumber: sensor.SerialNumber,
+		Address:      sensor.Addr,
+		Time:         now,
+		RSSI:         cln.ReadRSSI(),
+		Latency:      now.Sub(started),
+		Raw:          frames,
+		Info:         info,
+		RawFields:    rawFields,
+	}, nil
+}
+
+func (sensor *BleSensor) connect(ctx context.Context) (Conn, error) {
 	log.Debugf("connecting to device")
-	ctx := ble.WithSigHandler(context.WithTimeout(context.Background(), sensor.ScanDuration))
-	cln, err := ble.Connect(ctx, filter)
+	started := time.Now()
+	cln, err := sensor.transport().Connect(ctx, sensor.Addr)
+	sensor.observe(PhaseConnect, started, err)
 	if err != nil {
-		return airthings.SensorValues{}, errors.Wrap(err, "couldn't connect to ble")
+		return nil, errors.Wrap(err, "couldn't connect to ble")
 	}
+	return cln, nil
+}
 
-	// Normally, the connection is disconnected by us after our exploration.
-	// However, it can be asynchronously disconnected by the remote peripheral.
-	// So we wait(detect) the disconnection in the go routine.
-	done := make(chan struct{})
-	go func() {
-		<-cln.Disconnected()
-		log.Debugf("device disconnected")
-		close(done)
-	}()
-	defer func() {
-		log.Debugf("closing connection")
-		_ = cln.CancelConnection()
-		<-done
-	}()
+// reads through the characteristic handles known from previous connections,
+// and only discovers them if there are none yet or reading through them fails
+func (sensor *BleSensor) readFrames(cln Conn, model *Model) ([][]byte, error) {
+	sensor.mu.Lock()
+	characteristics := sensor.characteristics
+	sensor.mu.Unlock()
 
-	// TODO move to init
-	serviceUuid, err := ble.Parse(sensorServiceUuidStr)
+	if characteristics != nil {
+		started := time.Now()
+		frames, err := readCharacteristics(cln, characteristics)
+		sensor.observe(PhaseRead, started, err)
+		if err == nil {
+			return frames, nil
+		}
+		log.Debugf("failed to read through cached handles, rediscovering: %s", err)
+	}
+
+	started := time.Now()
+	characteristics, err := discoverCharacteristics(cln, model.Services, model.Characteristics)
+	sensor.observe(PhaseDiscover, started, err)
 	if err != nil {
-		log.Fatalf("could not parse service uuid: %s", err)
+		return nil, err
 	}
-	log.Debugf("discovering services")
-	services, err := cln.DiscoverServices([]ble.UUID{serviceUuid})
-	log.Debugf("finished discovering services")
+
+	sensor.mu.Lock()
+	sensor.characteristics = characteristics
+	sensor.mu.Unlock()
+
+	started = time.Now()
+	frames, err := readCharacteristics(cln, characteristics)
+	sensor.observe(PhaseRead, started, err)
+	return frames, err
+}
+
+func (sensor *BleSensor) observe(phase Phase, started time.Time, err error) {
+	if sensor.Observe != nil {
+		sensor.Observe(phase, time.Since(started), err)
+	}
+}
+
+// reads the battery voltage if BatteryInterval passed since the last time, or returns the last one known
+func (sensor *BleSensor) batteryVoltage(ctx context.Context, cln Conn, model *Model) (float32, bool) {
+	sensor.mu.Lock()
+	voltage, readAt := sensor.battery, sensor.batteryReadAt
+	sensor.mu.Unlock()
+
+	interval := sensor.BatteryInterval
+	if interval == 0 {
+		interval = DefaultBatteryInterval
+	}
+	if !readAt.IsZero() && time.Since(readAt) < interval {
+		return voltage, true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	read, err := sensor.readBatteryVoltage(ctx, cln, model)
+	if err != nil {
+		// the measurements are worth reporting even if the battery can't be read, it is tried again on the next read
+		log.Warnf("failed to read battery voltage of %s: %s", sensor.SerialNumber, err)
+		return voltage, !readAt.IsZero()
+	}
+
+	sensor.mu.Lock()
+	sensor.battery, sensor.batteryReadAt = read, time.Now()
+	sensor.mu.Unlock()
+	return read, true
+}
+
+func (sensor *BleSensor) readBatteryVoltage(ctx context.Context, cln Conn, model *Model) (float32, error) {
+	c, err := sensor.commandCharacteristic(cln, model)
 	if err != nil {
-		return airthings.SensorValues{}, errors.Wrap(err, "couldn't discover services")
+		return 0, err
 	}
-	if len(services) == 0 {
-		return airthings.SensorValues{}, errors.Wrap(err, "did not find expected sensor service")
+
+	voltage, err := readBatteryVoltage(ctx, cln, c)
+	if err != nil {
+		sensor.mu.Lock()
+		sensor.command = nil
+		sensor.mu.Unlock()
+	}
+	return voltage, err
+}
+
+// discovers the command characteristic unless its handle is known from previous connections
+func (sensor *BleSensor) commandCharacteristic(cln Conn, model *Model) (*ble.Characteristic, error) {
+	sensor.mu.Lock()
+	c := sensor.command
+	sensor.mu.Unlock()
+	if c != nil {
+		return c, nil
 	}
 
-	service := services[0]
-	// TODO move to init
-	charUuid, err := ble.Parse(sensorCharacteristicUuid)
+	characteristics, err := discoverCharacteristics(cln, model.Services, []ble.UUID{model.Command})
 	if err != nil {
-		log.Fatalf("could not parse characteristic uuid: %s", err)
+		return nil, err
 	}
+	c = characteristics[0]
+
+	sensor.mu.Lock()
+	sensor.command = c
+	sensor.mu.Unlock()
+	return c, nil
+}
+
+// looks the characteristics up in the given services (all of them if nil), returning them in the same order as uuids
+func discoverCharacteristics(cln Conn, services []ble.UUID, uuids []ble.UUID) ([]*ble.Characteristic, error) {
+	log.Debugf("discovering services")
+	discovered, err := cln.DiscoverServices(services)
+	log.Debugf("finished discovering services")
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't discover services")
+	}
+	if len(discovered) == 0 {
+		return nil, Permanent(errors.Wrap(ErrServiceMissing, "did not find expected sensor service"))
+	}
+
 	log.Debugf("discovering characteristics")
-	characteristics, err := cln.DiscoverCharacteristics([]ble.UUID{charUuid}, service)
+	found := map[string]*ble.Characteristic{}
+	for _, service := range discovered {
+		characteristics, err := cln.DiscoverCharacteristics(uuids, service)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't discover characteristic")
+		}
+		for _, c := range characteristics {
+			found[c.UUID.String()] = c
+		}
+	}
 	log.Debugf("finished discovering characteristics")
-	if err != nil {
-		return airthings.SensorValues{}, errors.Wrap(err, "couldn't discover characteristic")
+
+	characteristics := make([]*ble.Characteristic, len(uuids))
+	for i, uuid := range uuids {
+		c, ok := found[uuid.String()]
+		if !ok {
+			return nil, Permanent(errors.Wrapf(ErrCharacteristicMissing, "did not find expected characteristic %s", uuid))
+		}
+		characteristics[i] = c
+	}
+	return characteristics, nil
+}
+
+func readCharacteristics(cln Conn, characteristics []*ble.Characteristic) ([][]byte, error) {
+	frames := make([][]byte, len(characteristics))
+	for i, c := range characteristics {
+		log.Debugf("reading characteristic %s", c.UUID)
+		var err error
+		frames[i], err = cln.ReadCharacteristic(c)
+		log.Debugf("finished reading characteristic")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read characteristic value")
+		}
 	}
-	if len(characteristics) == 0 {
-		return airthings.SensorValues{}, errors.Wrap(err, "did not find expected characteristic")
+	return frames, nil
+}
+
+// falls back to Wave Plus for sensors constructed without a model
+func (sensor *BleSensor) model() *Model {
+	if sensor.Model == nil {
+		return WavePlus
 	}
-	c := characteristics[0]
+	return sensor.Model
+}
 
-	log.Debugf("reading characteristic")
-	sensorBytes, err := cln.ReadCharacteristic(c)
-	log.Debugf("finished reading characteristic")
-	if err != nil {
-		return airthings.SensorValues{}, errors.Wrap(err, "failed to read characteristic value")
-	}
-
-	sensorUnpacked := rawSensorValues{}
-	buf := bytes.NewBuffer(sensorBytes)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i0_unk)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i1_humidity)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i2_unk)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i3_unk)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i4_radonShort)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i5_radonLong)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i6_temperature)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i7_atm_pressure)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i8_co2)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i9_voc)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i10_unk)
-	_ = binary.Read(buf, binary.LittleEndian, &sensorUnpacked.i11_unk)
-
-	return refineRawValues(sensorUnpacked), nil
-}
-
-func refineRawValues(raw rawSensorValues) airthings.SensorValues {
-	return airthings.SensorValues{
-		Humidity:    float32(raw.i1_humidity) / 2.0,
-		RadonShort:  raw.i4_radonShort,
-		RadonLong:   raw.i5_radonLong,
-		Temperature: float32(raw.i6_temperature) / 100.0,
-		AtmPressure: float32(raw.i7_atm_pressure) / 50.0,
-		Co2Level:    float32(raw.i8_co2),
-		VocLevel:    float32(raw.i9_voc),
-	}
-}
-
-const sensorServiceUuidStr = "b42e1c08ade711e489d3123b93f75cba"
-const sensorCharacteristicUuid = "b42e2a68ade711e489d3123b93f75cba"
-
-type rawSensorValues struct {
-	i0_unk          uint8
-	i1_humidity     uint8
-	i2_unk          uint8
-	i3_unk          uint8
-	i4_radonShort   uint16
-	i5_radonLong    uint16
-	i6_temperature  uint16
-	i7_atm_pressure uint16
-	i8_co2          uint16
-	i9_voc          uint16
-	i10_unk         uint16
-	i11_unk         uint16
+// sleeps for the given duration, returning early with ctx.Err() if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,157 @@
+package waveplus_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/frame"
+	"github.com/alepar/airthings/airthings/waveplus/waveplustest"
+)
+
+func TestReceive(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	sensor := newTestSensor(device, 1)
+
+	reading, err := sensor.ReceiveContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if reading.SerialNumber != "2930123456" || reading.Address != wavePlusAddr || reading.RSSI != device.RSSI {
+		t.Errorf("unexpected identity %s at %s, RSSI %d", reading.SerialNumber, reading.Address, reading.RSSI)
+	}
+	if reading.Humidity != testValues.Humidity || reading.RadonShort != testValues.RadonShort ||
+		reading.Co2Level != testValues.Co2Level || !reading.Has(airthings.FieldVocLevel) {
+		t.Errorf("unexpected values %+v", reading.SensorValues)
+	}
+}
+
+func TestReceiveDeviceNotFound(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.SetSilent(true)
+	sensor := newTestSensor(device, 2)
+	sensor.ScanDuration = 20 * time.Millisecond
+
+	_, err := sensor.ReceiveContext(context.Background())
+	if !errors.Is(err, waveplus.ErrDeviceNotFound) {
+		t.Fatalf("expected ErrDeviceNotFound, got %v", err)
+	}
+	if connects := device.Connects(); connects != 0 {
+		t.Errorf("expected no connects to a silent device, got %d", connects)
+	}
+}
+
+func TestReceiveKeepsConnectErrorClass(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.FailConnects(waveplus.ErrDeviceNotFound)
+	sensor := newTestSensor(device, 1)
+
+	_, err := sensor.ReceiveContext(context.Background())
+	if !errors.Is(err, waveplus.ErrDeviceNotFound) {
+		t.Fatalf("expected ErrDeviceNotFound, got %v", err)
+	}
+}
+
+func TestReceiveRediscoversHandles(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	sensor := newTestSensor(device, 1)
+	var phases []waveplus.Phase
+	sensor.Observe = func(phase waveplus.Phase, d time.Duration, err error) {
+		phases = append(phases, phase)
+	}
+
+	if _, err := sensor.ReceiveContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := sensor.ReceiveContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertPhases(t, phases, waveplus.PhaseConnect, waveplus.PhaseDiscover, waveplus.PhaseRead, waveplus.PhaseConnect, waveplus.PhaseRead)
+
+	// e.g. the handles moved after a firmware update
+	phases = nil
+	device.FailReads(errors.New("invalid handle"))
+	changed := testValues
+	changed.Co2Level = 800
+	device.SetValue(waveplus.WavePlus.Characteristics[0], waveplustest.EncodeWavePlus(changed))
+
+	reading, err := sensor.ReceiveContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if reading.Co2Level != 800 {
+		t.Errorf("expected the changed CO2 level, got %v", reading.Co2Level)
+	}
+	assertPhases(t, phases, waveplus.PhaseConnect, waveplus.PhaseRead, waveplus.PhaseDiscover, waveplus.PhaseRead)
+	if connects := device.Connects(); connects != 3 {
+		t.Errorf("expected rediscovery on the same connection, got %d connects", connects)
+	}
+}
+
+func assertPhases(t *testing.T, got []waveplus.Phase, want ...waveplus.Phase) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected phases %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected phases %v, got %v", want, got)
+		}
+	}
+}
+
+func TestReceiveBatteryVoltage(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	states := 0
+	device.HandleCommand(frame.CommandState, func(cmd []byte) [][]byte {
+		states++
+		response := make([]byte, 30)
+		response[0] = frame.CommandState
+		binary.LittleEndian.PutUint16(response[18:], 2900)
+		return [][]byte{response}
+	})
+	sensor := newTestSensor(device, 1)
+
+	for i := 0; i < 3; i++ {
+		reading, err := sensor.ReceiveContext(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reading.Has(airthings.FieldBatteryVoltage) || reading.BatteryVoltage != 2.9 {
+			t.Errorf("expected 2.9V on read %d, got %+v", i, reading.SensorValues)
+		}
+	}
+	if states != 1 {
+		t.Errorf("expected the battery to be read once per BatteryInterval, got %d reads", states)
+	}
+
+	sensor.BatteryInterval = time.Nanosecond
+	if _, err := sensor.ReceiveContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if states != 2 {
+		t.Errorf("expected the battery to be read again once BatteryInterval passed, got %d reads", states)
+	}
+}
+
+func TestReceiveWithoutBatteryResponse(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.HandleCommand(frame.CommandState, func(cmd []byte) [][]byte {
+		return nil
+	})
+	sensor := newTestSensor(device, 1)
+
+	// the measurements are still reported, without the battery
+	reading, err := sensor.ReceiveContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if reading.Has(airthings.FieldBatteryVoltage) || !reading.Has(airthings.FieldCo2Level) {
+		t.Errorf("expected the measurements without the battery, got %+v", reading.SensorValues)
+	}
+}
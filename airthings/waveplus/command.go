@@ -0,0 +1,65 @@
+package waveplus
+
+import (
+	"context"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alepar/airthings/airthings/waveplus/frame"
+)
+
+// writes the command, and passes every notification that follows to handle until it returns true
+func exchange(ctx context.Context, cln Conn, c *ble.Characteristic, cmd []byte, handle func(notification []byte) bool) error {
+	if c.CCCD == nil {
+		if _, err := cln.DiscoverDescriptors(nil, c); err != nil {
+			return errors.Wrap(err, "couldn't discover command descriptors")
+		}
+	}
+
+	notifications := make(chan []byte, 16)
+	err := cln.Subscribe(c, false, func(notification []byte) {
+		select {
+		case notifications <- append([]byte{}, notification...):
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "couldn't subscribe to command responses")
+	}
+	defer func() {
+		if err := cln.Unsubscribe(c, false); err != nil {
+			log.Debugf("failed to unsubscribe from command responses: %s", err)
+		}
+	}()
+
+	log.Debugf("writing command 0x%x", cmd)
+	if err := cln.WriteCharacteristic(c, cmd, false); err != nil {
+		return errors.Wrap(err, "failed to write command")
+	}
+
+	for {
+		select {
+		case notification := <-notifications:
+			if handle(notification) {
+				return nil
+			}
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "no response to command")
+		}
+	}
+}
+
+// units: V
+func readBatteryVoltage(ctx context.Context, cln Conn, c *ble.Characteristic) (float32, error) {
+	var response []byte
+	err := exchange(ctx, cln, c, []byte{frame.CommandState}, func(notification []byte) bool {
+		response = notification
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return frame.DecodeBatteryVoltage(response)
+}
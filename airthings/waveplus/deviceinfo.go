@@ -0,0 +1,90 @@
+package waveplus
+
+import (
+	"strings"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+// standard BLE Device Information service
+var (
+	deviceInformationService = ble.UUID16(0x180a)
+
+	manufacturerNameCharacteristic = ble.UUID16(0x2a29)
+	modelNumberCharacteristic      = ble.UUID16(0x2a24)
+	firmwareRevisionCharacteristic = ble.UUID16(0x2a26)
+	hardwareRevisionCharacteristic = ble.UUID16(0x2a27)
+)
+
+// Info returns the device information read on the first successful connection, false if there was none yet
+func (sensor *BleSensor) Info() (airthings.DeviceInfo, bool) {
+	sensor.mu.Lock()
+	defer sensor.mu.Unlock()
+	if sensor.info == nil {
+		return airthings.DeviceInfo{}, false
+	}
+	return *sensor.info, true
+}
+
+// reads the device information unless it is known from previous connections, as it only changes with the firmware
+func (sensor *BleSensor) deviceInfo(cln Conn) (*airthings.DeviceInfo, error) {
+	sensor.mu.Lock()
+	info := sensor.info
+	sensor.mu.Unlock()
+	if info != nil {
+		return info, nil
+	}
+
+	info, err := readDeviceInfo(cln)
+	if err != nil {
+		return nil, err
+	}
+
+	sensor.mu.Lock()
+	sensor.info = info
+	sensor.mu.Unlock()
+	return info, nil
+}
+
+// a device without the service, or some of its characteristics, gets the corresponding fields empty
+func readDeviceInfo(cln Conn) (*airthings.DeviceInfo, error) {
+	log.Debugf("discovering device information")
+	services, err := cln.DiscoverServices([]ble.UUID{deviceInformationService})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't discover device information service")
+	}
+
+	info := &airthings.DeviceInfo{}
+	fields := map[string]*string{
+		manufacturerNameCharacteristic.String(): &info.Manufacturer,
+		modelNumberCharacteristic.String():      &info.Model,
+		firmwareRevisionCharacteristic.String(): &info.Firmware,
+		hardwareRevisionCharacteristic.String(): &info.Hardware,
+	}
+	for _, service := range services {
+		characteristics, err := cln.DiscoverCharacteristics([]ble.UUID{
+			manufacturerNameCharacteristic, modelNumberCharacteristic, firmwareRevisionCharacteristic, hardwareRevisionCharacteristic,
+		}, service)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't discover device information characteristics")
+		}
+		for _, c := range characteristics {
+			field, ok := fields[c.UUID.String()]
+			if !ok {
+				continue
+			}
+			value, err := cln.ReadCharacteristic(c)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read device information %s", c.UUID)
+			}
+			// some devices pad the strings with NULs
+			*field = strings.TrimRight(string(value), "\x00 ")
+		}
+	}
+	log.Debugf("finished discovering device information: %+v", *info)
+	return info, nil
+}
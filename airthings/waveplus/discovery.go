@@ -0,0 +1,88 @@
+package waveplus
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+// Discovery is emitted by BleScanner.Discover the first time a sensor is seen
+type Discovery struct {
+	SerialNumber string
+	Model        *Model
+	Address      string
+	RSSI         int
+	Time         time.Time
+
+	// ready to Receive from
+	Sensor airthings.Sensor
+}
+
+// Discover scans for up to ScanDuration and emits every supported sensor as soon as it is first seen.
+// If expected serial numbers are given, the scan stops early once all of them were found.
+// The returned channel is closed when the scan is over; it has to be drained promptly,
+// as advertisements are not processed while a send is blocked.
+func (scanner *BleScanner) Discover(ctx context.Context, expected ...string) <-chan Discovery {
+	discoveries := make(chan Discovery, len(expected))
+	ctx, cancel := context.WithTimeout(ctx, scanner.ScanDuration)
+
+	pending := map[string]bool{}
+	for _, serialNr := range expected {
+		pending[serialNr] = true
+	}
+	seen := map[string]bool{}
+
+	handler := func(a Advertisement) {
+		if !supportedModelsFilter(a) {
+			return
+		}
+		serialNr := manufacturerDataToSerialNumber(a.ManufacturerData)
+		if seen[serialNr] {
+			return
+		}
+		seen[serialNr] = true
+
+		addr := a.Address
+		model, _ := ModelBySerialNumber(serialNr)
+		discovery := Discovery{
+			SerialNumber: serialNr,
+			Model:        model,
+			Address:      addr,
+			RSSI:         a.RSSI,
+			Time:         time.Now(),
+			Sensor:       scanner.newSensor(serialNr, addr, model),
+		}
+		select {
+		case discoveries <- discovery:
+		case <-ctx.Done():
+			return
+		}
+
+		delete(pending, serialNr)
+		if len(expected) > 0 && len(pending) == 0 {
+			log.Debugf("found all %d expected sensors, stopping the scan", len(expected))
+			cancel()
+		}
+	}
+
+	go func() {
+		defer close(discoveries)
+		defer cancel()
+
+		err := scanner.transport().Scan(ble.WithSigHandler(ctx, cancel), handler)
+		switch errors.Cause(err) {
+		case nil:
+		case context.DeadlineExceeded:
+		case context.Canceled:
+		default:
+			log.Errorf("failed to discover devices: %s", err)
+		}
+	}()
+
+	return discoveries
+}
@@ -0,0 +1,98 @@
+package waveplus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/waveplustest"
+)
+
+func TestDiscoverEmitsEverySensorOnce(t *testing.T) {
+	other := waveplustest.NewWavePlus(2930000002, "00:11:22:33:44:02", testValues)
+	unsupported := waveplustest.NewWavePlus(1234567890, "00:11:22:33:44:03", testValues)
+
+	// both adapters hear both sensors
+	scanner := &waveplus.BleScanner{
+		ScanDuration: 50 * time.Millisecond,
+		Retries:      1,
+		Transport: waveplus.NewMultiTransport(
+			waveplustest.NewTransport(waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues), other, unsupported),
+			waveplustest.NewTransport(waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues), other),
+		),
+	}
+
+	seen := map[string]int{}
+	for discovery := range scanner.Discover(context.Background()) {
+		seen[discovery.SerialNumber]++
+		if discovery.Model != waveplus.WavePlus || discovery.Sensor == nil {
+			t.Errorf("expected a ready %s sensor, got %+v", waveplus.WavePlus.Name, discovery)
+		}
+	}
+
+	if len(seen) != 2 || seen["2930123456"] != 1 || seen["2930000002"] != 1 {
+		t.Errorf("expected both Wave Plus sensors once, got %v", seen)
+	}
+}
+
+func TestDiscoverStopsOnceExpectedFound(t *testing.T) {
+	scanner := &waveplus.BleScanner{
+		ScanDuration: time.Minute,
+		Retries:      1,
+		Transport: waveplustest.NewTransport(
+			waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues),
+			waveplustest.NewWavePlus(2930000002, "00:11:22:33:44:02", testValues),
+		),
+	}
+
+	discoveries := scanner.Discover(context.Background(), "2930123456", "2930000002")
+	var found []string
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case discovery, ok := <-discoveries:
+			if !ok {
+				done = true
+				break
+			}
+			found = append(found, discovery.SerialNumber)
+		case <-timeout:
+			t.Fatalf("expected the scan to stop once both sensors were found, found %v", found)
+		}
+	}
+
+	if len(found) != 2 {
+		t.Errorf("expected both sensors, got %v", found)
+	}
+}
+
+func TestDiscoverClosesWhenScanEnds(t *testing.T) {
+	scanner := &waveplus.BleScanner{
+		ScanDuration: 50 * time.Millisecond,
+		Retries:      1,
+		Transport:    waveplustest.NewTransport(waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)),
+	}
+
+	// never found, so only the end of the scan closes the channel
+	discoveries := scanner.Discover(context.Background(), "2930000002")
+	start := time.Now()
+	var found []string
+	for {
+		select {
+		case discovery, ok := <-discoveries:
+			if !ok {
+				if elapsed := time.Since(start); elapsed < scanner.ScanDuration {
+					t.Errorf("expected the scan to last %s, closed after %s", scanner.ScanDuration, elapsed)
+				}
+				if len(found) != 1 || found[0] != "2930123456" {
+					t.Errorf("expected the sensor in range, got %v", found)
+				}
+				return
+			}
+			found = append(found, discovery.SerialNumber)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the channel to be closed when the scan ends")
+		}
+	}
+}
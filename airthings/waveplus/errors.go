@@ -0,0 +1,57 @@
+package waveplus
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings/waveplus/frame"
+)
+
+// Failure classes, to be checked with errors.Is
+var (
+	// the device did not advertise while we were looking for it
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// the device was seen, but the connection was not established in time
+	ErrConnectTimeout = errors.New("connect timed out")
+
+	// connected, but the device does not have the service we read from
+	ErrServiceMissing = errors.New("sensor service missing")
+
+	// connected, but the service does not have the characteristic we read from
+	ErrCharacteristicMissing = errors.New("sensor characteristic missing")
+
+	// the characteristic value is shorter than the model layout requires
+	ErrShortFrame = frame.ErrShortFrame
+
+	// other scans or connects kept the adapter until the deadline, the device may well be around
+	ErrAdapterBusy = errors.New("adapter busy")
+
+	// the BLE adapter itself failed, it likely needs to be reopened
+	ErrAdapterDown = errors.New("adapter down")
+)
+
+// classifies err as one of the failure classes, keeping err as the cause:
+// both errors.Is(result, class) and errors.Is(result, err) hold
+func classify(class error, err error) error {
+	if err == nil {
+		return class
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.class.Error() + ": " + e.err.Error()
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
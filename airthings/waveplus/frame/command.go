@@ -0,0 +1,44 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Commands written to the command characteristic, answered with a notification on the same characteristic
+const (
+	// asks for the sensor state, including the battery voltage
+	CommandState = 0x6d
+)
+
+// bytes in the response to CommandState: command echo, status, then uint32, 12 bytes and 6 uint16
+const stateResponseLength = 2 + 4 + 12 + 6*2
+
+// UnexpectedResponseError is returned for a response that does not echo the command it is supposed to answer
+type UnexpectedResponseError struct {
+	Command  uint8
+	Response uint8
+}
+
+func (e *UnexpectedResponseError) Error() string {
+	return fmt.Sprintf("expected response to command 0x%02x, got 0x%02x", e.Command, e.Response)
+}
+
+// DecodeBatteryVoltage extracts the battery voltage from the response to CommandState, units: V
+func DecodeBatteryVoltage(response []byte) (float32, error) {
+	if len(response) < 1 {
+		return 0, errors.Wrap(ErrShortFrame, "empty response")
+	}
+	if response[0] != CommandState {
+		return 0, &UnexpectedResponseError{Command: CommandState, Response: response[0]}
+	}
+	if len(response) < stateResponseLength {
+		return 0, errors.Wrapf(ErrShortFrame, "expected %d bytes, got %d", stateResponseLength, len(response))
+	}
+
+	// first of the uint16s, units: mV
+	millivolts := binary.LittleEndian.Uint16(response[2+4+12:])
+	return float32(millivolts) / 1000.0, nil
+}
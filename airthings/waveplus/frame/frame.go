@@ -0,0 +1,141 @@
+// Package frame decodes the current values characteristic of Airthings Wave Plus.
+// It has no dependency on a BLE stack, so it can be used on captured bytes as well.
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+const (
+	// the only frame layout we know of
+	Version = 1
+
+	// bytes in a frame of the supported version
+	Length = 20
+
+	// radon estimates above this are sentinels, e.g. 0xFFFF during the first hours after power-on
+	MaxRadon = 16383
+
+	// reported in place of a measurement that is not available yet
+	unavailable = 0xffff
+)
+
+// ErrShortFrame is returned when a frame has fewer bytes than its layout requires
+var ErrShortFrame = errors.New("short frame")
+
+// UnsupportedVersionError is returned for frames with a leading version byte other than Version
+type UnsupportedVersionError struct {
+	Version uint8
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported frame version %d", e.Version)
+}
+
+// Decode validates the frame and turns it into measurements
+func Decode(frame []byte) (airthings.SensorValues, error) {
+	if err := validate(frame); err != nil {
+		return airthings.SensorValues{}, err
+	}
+	return refineRawValues(unpack(frame)), nil
+}
+
+// RawFields validates the frame and returns the fields Decode leaves out, as their meaning is not known
+func RawFields(frame []byte) ([]airthings.RawField, error) {
+	if err := validate(frame); err != nil {
+		return nil, err
+	}
+
+	raw := unpack(frame)
+	return []airthings.RawField{
+		{Index: 0, Value: uint16(raw.i0_version)},
+		{Index: 2, Value: uint16(raw.i2_unk)},
+		{Index: 3, Value: uint16(raw.i3_unk)},
+		{Index: 10, Value: raw.i10_unk},
+		{Index: 11, Value: raw.i11_unk},
+	}, nil
+}
+
+func validate(frame []byte) error {
+	if len(frame) < 1 {
+		return errors.Wrap(ErrShortFrame, "empty frame")
+	}
+	if frame[0] != Version {
+		return &UnsupportedVersionError{Version: frame[0]}
+	}
+	if len(frame) < Length {
+		return errors.Wrapf(ErrShortFrame, "expected %d bytes, got %d", Length, len(frame))
+	}
+	return nil
+}
+
+func unpack(frame []byte) rawSensorValues {
+	return rawSensorValues{
+		i0_version:      frame[0],
+		i1_humidity:     frame[1],
+		i2_unk:          frame[2],
+		i3_unk:          frame[3],
+		i4_radonShort:   binary.LittleEndian.Uint16(frame[4:]),
+		i5_radonLong:    binary.LittleEndian.Uint16(frame[6:]),
+		i6_temperature:  binary.LittleEndian.Uint16(frame[8:]),
+		i7_atm_pressure: binary.LittleEndian.Uint16(frame[10:]),
+		i8_co2:          binary.LittleEndian.Uint16(frame[12:]),
+		i9_voc:          binary.LittleEndian.Uint16(frame[14:]),
+		i10_unk:         binary.LittleEndian.Uint16(frame[16:]),
+		i11_unk:         binary.LittleEndian.Uint16(frame[18:]),
+	}
+}
+
+// only sets the fields that hold an actual measurement, leaving sentinels out
+func refineRawValues(raw rawSensorValues) airthings.SensorValues {
+	values := airthings.SensorValues{}
+	if raw.i1_humidity != 0xff {
+		values.Humidity = float32(raw.i1_humidity) / 2.0
+		values.Fields |= airthings.FieldHumidity
+	}
+	if raw.i4_radonShort <= MaxRadon {
+		values.RadonShort = raw.i4_radonShort
+		values.Fields |= airthings.FieldRadonShort
+	}
+	if raw.i5_radonLong <= MaxRadon {
+		values.RadonLong = raw.i5_radonLong
+		values.Fields |= airthings.FieldRadonLong
+	}
+	if raw.i6_temperature != unavailable {
+		values.Temperature = float32(raw.i6_temperature) / 100.0
+		values.Fields |= airthings.FieldTemperature
+	}
+	if raw.i7_atm_pressure != unavailable {
+		values.AtmPressure = float32(raw.i7_atm_pressure) / 50.0
+		values.Fields |= airthings.FieldAtmPressure
+	}
+	if raw.i8_co2 != unavailable {
+		values.Co2Level = float32(raw.i8_co2)
+		values.Fields |= airthings.FieldCo2Level
+	}
+	if raw.i9_voc != unavailable {
+		values.VocLevel = float32(raw.i9_voc)
+		values.Fields |= airthings.FieldVocLevel
+	}
+	return values
+}
+
+type rawSensorValues struct {
+	i0_version      uint8
+	i1_humidity     uint8
+	i2_unk          uint8
+	i3_unk          uint8
+	i4_radonShort   uint16
+	i5_radonLong    uint16
+	i6_temperature  uint16
+	i7_atm_pressure uint16
+	i8_co2          uint16
+	i9_voc          uint16
+	i10_unk         uint16
+	i11_unk         uint16
+}
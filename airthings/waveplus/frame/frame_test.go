@@ -0,0 +1,99 @@
+package frame
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+func TestDecode(t *testing.T) {
+	// humidity 45.5%, radon 60 and 54 Bq/m3, 21.37 C, 1002.5 hPa, 612 ppm CO2, 87 ppb VOC
+	valid := []byte{
+		0x01, 0x5b, 0x00, 0x00,
+		0x3c, 0x00, 0x36, 0x00,
+		0x59, 0x08, 0xcd, 0xc3,
+		0x64, 0x02, 0x57, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	sentinels := []byte{
+		0x01, 0xff, 0x00, 0x00,
+		0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	warmingUp := append([]byte{}, valid...)
+	warmingUp[4], warmingUp[5] = 0x00, 0x40 // radon short just above MaxRadon
+	warmingUp[6], warmingUp[7] = 0xff, 0xff
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		want    airthings.SensorValues
+		short   bool
+		version uint8
+	}{
+		{name: "empty", frame: nil, short: true},
+		{name: "short", frame: valid[:Length-1], short: true},
+		{name: "wrong version", frame: append([]byte{0x02}, valid[1:]...), version: 0x02},
+		{
+			name:  "valid",
+			frame: valid,
+			want: airthings.SensorValues{
+				Humidity:    45.5,
+				RadonShort:  60,
+				RadonLong:   54,
+				Temperature: 21.37,
+				AtmPressure: 1002.5,
+				Co2Level:    612,
+				VocLevel:    87,
+				Fields: airthings.FieldHumidity | airthings.FieldRadonShort | airthings.FieldRadonLong |
+					airthings.FieldTemperature | airthings.FieldAtmPressure | airthings.FieldCo2Level | airthings.FieldVocLevel,
+			},
+		},
+		{name: "sentinels", frame: sentinels, want: airthings.SensorValues{}},
+		{
+			name:  "radon warming up",
+			frame: warmingUp,
+			want: airthings.SensorValues{
+				Humidity:    45.5,
+				Temperature: 21.37,
+				AtmPressure: 1002.5,
+				Co2Level:    612,
+				VocLevel:    87,
+				Fields: airthings.FieldHumidity | airthings.FieldTemperature | airthings.FieldAtmPressure |
+					airthings.FieldCo2Level | airthings.FieldVocLevel,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := Decode(test.frame)
+
+			switch {
+			case test.short:
+				if !errors.Is(err, ErrShortFrame) {
+					t.Fatalf("expected ErrShortFrame, got %v", err)
+				}
+			case test.version != 0:
+				var versionErr *UnsupportedVersionError
+				if !errors.As(err, &versionErr) {
+					t.Fatalf("expected UnsupportedVersionError, got %v", err)
+				}
+				if versionErr.Version != test.version {
+					t.Errorf("expected version %d, got %d", test.version, versionErr.Version)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if values != test.want {
+					t.Errorf("expected %+v, got %+v", test.want, values)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package waveplus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/waveplus/frame"
+)
+
+// Model describes how to read the current values from one Airthings product
+type Model struct {
+	Name string
+
+	// first 4 digits of the serial number, which identify the product
+	SerialPrefix string
+
+	// services to look the characteristics up in, nil means all of them
+	Services []ble.UUID
+
+	// read in this order and passed to Decode
+	Characteristics []ble.UUID
+
+	// turns characteristic values into measurements, setting SensorValues.Fields to the valid ones
+	Decode func(frames [][]byte) (airthings.SensorValues, error)
+
+	// extracts the values Decode leaves out as their meaning is not known, nil means there are none
+	RawFields func(frames [][]byte) ([]airthings.RawField, error)
+
+	// characteristic to send commands to, e.g. to read the battery voltage; nil means not supported
+	Command ble.UUID
+}
+
+// Wave Mini reports no sentinels, so all of its measurements are always valid
+const waveMiniFields = airthings.FieldTemperature | airthings.FieldHumidity | airthings.FieldVocLevel
+
+var (
+	Wave = &Model{
+		Name:         "Wave",
+		SerialPrefix: "2900",
+		Characteristics: []ble.UUID{
+			ble.UUID16(0x2a6f), // humidity
+			ble.UUID16(0x2a6e), // temperature
+			ble.MustParse("b42e01aaade711e489d3123b93f75cba"), // radon short term
+			ble.MustParse("b42e0a4cade711e489d3123b93f75cba"), // radon long term
+		},
+		Decode: decodeWave,
+	}
+
+	WaveMini = &Model{
+		Name:            "Wave Mini",
+		SerialPrefix:    "2920",
+		Services:        []ble.UUID{ble.MustParse("b42e3882ade711e489d3123b93f75cba")},
+		Characteristics: []ble.UUID{ble.MustParse("b42e3b98ade711e489d3123b93f75cba")},
+		Decode:          decodeWaveMini,
+	}
+
+	WavePlus = &Model{
+		Name:            "Wave Plus",
+		SerialPrefix:    "2930",
+		Services:        []ble.UUID{ble.MustParse("b42e1c08ade711e489d3123b93f75cba")},
+		Characteristics: []ble.UUID{ble.MustParse("b42e2a68ade711e489d3123b93f75cba")},
+		Decode:          decodeWavePlus,
+		RawFields:       rawFieldsWavePlus,
+		Command:         ble.MustParse("b42e2d06ade711e489d3123b93f75cba"),
+	}
+
+	Wave2 = &Model{
+		Name:            "Wave 2",
+		SerialPrefix:    "2950",
+		Services:        []ble.UUID{ble.MustParse("b42e4a8eade711e489d3123b93f75cba")},
+		Characteristics: []ble.UUID{ble.MustParse("b42e4dccade711e489d3123b93f75cba")},
+		Decode:          decodeWave2,
+		Command:         ble.MustParse("b42e50d8ade711e489d3123b93f75cba"),
+	}
+)
+
+// Models lists all the supported products
+var Models = []*Model{Wave, WaveMini, WavePlus, Wave2}
+
+// ModelBySerialNumber looks the product up by the serial number prefix
+func ModelBySerialNumber(serialNr string) (*Model, bool) {
+	for _, model := range Models {
+		if strings.HasPrefix(serialNr, model.SerialPrefix) {
+			return model, true
+		}
+	}
+	return nil, false
+}
+
+// humidity, temperature, radon short and long term, each in its own characteristic
+func decodeWave(frames [][]byte) (airthings.SensorValues, error) {
+	var raw [4]uint16
+	for i, frame := range frames {
+		if len(frame) < 2 {
+			return airthings.SensorValues{}, errors.Wrapf(ErrShortFrame, "expected at least 2 bytes in frame %d, got %d", i, len(frame))
+		}
+		raw[i] = binary.LittleEndian.Uint16(frame)
+	}
+
+	values := airthings.SensorValues{
+		Humidity:    float32(raw[0]) / 100.0,
+		Temperature: float32(int16(raw[1])) / 100.0,
+		Fields:      airthings.FieldHumidity | airthings.FieldTemperature,
+	}
+	setRadon(&values, raw[2], raw[3])
+	return values, nil
+}
+
+func decodeWavePlus(frames [][]byte) (airthings.SensorValues, error) {
+	return frame.Decode(frames[0])
+}
+
+func rawFieldsWavePlus(frames [][]byte) ([]airthings.RawField, error) {
+	return frame.RawFields(frames[0])
+}
+
+func decodeWaveMini(frames [][]byte) (airthings.SensorValues, error) {
+	var raw [6]uint16
+	if err := binary.Read(bytes.NewReader(frames[0]), binary.LittleEndian, &raw); err != nil {
+		return airthings.SensorValues{}, classify(ErrShortFrame, errors.Wrap(err, "failed to unpack frame"))
+	}
+
+	return airthings.SensorValues{
+		Temperature: float32(raw[1])/100.0 - 273.15, // reported in Kelvin
+		Humidity:    float32(raw[3]) / 100.0,
+		VocLevel:    float32(raw[4]),
+		Fields:      waveMiniFields,
+	}, nil
+}
+
+func decodeWave2(frames [][]byte) (airthings.SensorValues, error) {
+	var raw struct {
+		Version  uint8
+		Humidity uint8
+		_        [2]uint8
+		Values   [8]uint16
+	}
+	if err := binary.Read(bytes.NewReader(frames[0]), binary.LittleEndian, &raw); err != nil {
+		return airthings.SensorValues{}, classify(ErrShortFrame, errors.Wrap(err, "failed to unpack frame"))
+	}
+
+	values := airthings.SensorValues{
+		Humidity:    float32(raw.Humidity) / 2.0,
+		Temperature: float32(raw.Values[2]) / 100.0,
+		Fields:      airthings.FieldHumidity | airthings.FieldTemperature,
+	}
+	setRadon(&values, raw.Values[0], raw.Values[1])
+	return values, nil
+}
+
+// leaves out radon estimates the sensor has not computed yet
+func setRadon(values *airthings.SensorValues, short uint16, long uint16) {
+	if short <= frame.MaxRadon {
+		values.RadonShort = short
+		values.Fields |= airthings.FieldRadonShort
+	}
+	if long <= frame.MaxRadon {
+		values.RadonLong = long
+		values.Fields |= airthings.FieldRadonLong
+	}
+}
@@ -0,0 +1,162 @@
+package waveplus_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/waveplus"
+)
+
+func TestModelsDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		model  *waveplus.Model
+		frames [][]byte
+		want   airthings.SensorValues
+		short  bool
+	}{
+		{
+			// humidity 45.5%, -3.25 C, radon 60 and 54 Bq/m3
+			name:   "Wave",
+			model:  waveplus.Wave,
+			frames: [][]byte{{0xc6, 0x11}, {0xbb, 0xfe}, {0x3c, 0x00}, {0x36, 0x00}},
+			want: airthings.SensorValues{
+				Humidity:    45.5,
+				Temperature: -3.25,
+				RadonShort:  60,
+				RadonLong:   54,
+				Fields:      airthings.FieldHumidity | airthings.FieldTemperature | airthings.FieldRadonShort | airthings.FieldRadonLong,
+			},
+		},
+		{
+			name:   "Wave radon warming up",
+			model:  waveplus.Wave,
+			frames: [][]byte{{0xc6, 0x11}, {0x59, 0x08}, {0xff, 0xff}, {0xff, 0xff}},
+			want: airthings.SensorValues{
+				Humidity:    45.5,
+				Temperature: 21.37,
+				Fields:      airthings.FieldHumidity | airthings.FieldTemperature,
+			},
+		},
+		{
+			name:   "Wave short",
+			model:  waveplus.Wave,
+			frames: [][]byte{{0xc6, 0x11}, {0x59}, {0x3c, 0x00}, {0x36, 0x00}},
+			short:  true,
+		},
+		{
+			// 294.52 K, humidity 45.5%, 87 ppb VOC
+			name:  "Wave Mini",
+			model: waveplus.WaveMini,
+			frames: [][]byte{{
+				0x01, 0x00, 0x0c, 0x73,
+				0x00, 0x00, 0xc6, 0x11,
+				0x57, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+			}},
+			want: airthings.SensorValues{
+				Temperature: 21.37,
+				Humidity:    45.5,
+				VocLevel:    87,
+				Fields:      airthings.FieldTemperature | airthings.FieldHumidity | airthings.FieldVocLevel,
+			},
+		},
+		{
+			name:   "Wave Mini short",
+			model:  waveplus.WaveMini,
+			frames: [][]byte{{0x01, 0x00, 0x0c, 0x73}},
+			short:  true,
+		},
+		{
+			// humidity 45.5%, radon 60 and 54 Bq/m3, 21.37 C
+			name:  "Wave 2",
+			model: waveplus.Wave2,
+			frames: [][]byte{{
+				0x01, 0x5b, 0x00, 0x00,
+				0x3c, 0x00, 0x36, 0x00,
+				0x59, 0x08, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+			}},
+			want: airthings.SensorValues{
+				Humidity:    45.5,
+				RadonShort:  60,
+				RadonLong:   54,
+				Temperature: 21.37,
+				Fields:      airthings.FieldHumidity | airthings.FieldTemperature | airthings.FieldRadonShort | airthings.FieldRadonLong,
+			},
+		},
+		{
+			// unlike the Wave, the temperature is unsigned
+			name:  "Wave 2 temperature above int16",
+			model: waveplus.Wave2,
+			frames: [][]byte{{
+				0x01, 0x5b, 0x00, 0x00,
+				0xff, 0xff, 0xff, 0xff,
+				0x00, 0x80, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00,
+			}},
+			want: airthings.SensorValues{
+				Humidity:    45.5,
+				Temperature: 327.68,
+				Fields:      airthings.FieldHumidity | airthings.FieldTemperature,
+			},
+		},
+		{
+			name:   "Wave 2 short",
+			model:  waveplus.Wave2,
+			frames: [][]byte{{0x01, 0x5b, 0x00, 0x00, 0x3c, 0x00}},
+			short:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := test.model.Decode(test.frames)
+
+			if test.short {
+				if !errors.Is(err, waveplus.ErrShortFrame) {
+					t.Fatalf("expected ErrShortFrame, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !approxEqual(values, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, values)
+			}
+		})
+	}
+}
+
+func TestModelBySerialNumber(t *testing.T) {
+	for serialNr, want := range map[string]*waveplus.Model{
+		"2900123456": waveplus.Wave,
+		"2920123456": waveplus.WaveMini,
+		"2930123456": waveplus.WavePlus,
+		"2950123456": waveplus.Wave2,
+		"2960123456": nil,
+		"1234567890": nil,
+	} {
+		model, ok := waveplus.ModelBySerialNumber(serialNr)
+		if model != want || ok != (want != nil) {
+			t.Errorf("%s: expected %v, got %v", serialNr, want, model)
+		}
+	}
+}
+
+// the decoders scale in float32
+func approxEqual(a, b airthings.SensorValues) bool {
+	close := func(x, y float32) bool {
+		return math.Abs(float64(x-y)) < 0.005
+	}
+	return a.Fields == b.Fields && a.RadonShort == b.RadonShort && a.RadonLong == b.RadonLong &&
+		close(a.Humidity, b.Humidity) && close(a.Temperature, b.Temperature) && close(a.AtmPressure, b.AtmPressure) &&
+		close(a.Co2Level, b.Co2Level) && close(a.VocLevel, b.VocLevel)
+}
@@ -0,0 +1,172 @@
+package waveplus
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// MultiTransport spreads the work over several adapters:
+// scans run on all of them at once, and every device is connected to through the adapter that hears it best,
+// failing over to the other adapters when that one breaks
+type MultiTransport struct {
+	Transports []Transport
+
+	mu sync.Mutex
+	// last RSSI per adapter index, by upper case device address
+	rssi map[string]map[int]int
+	// consecutive failures per adapter index
+	failures map[int]int
+}
+
+func NewMultiTransport(transports ...Transport) *MultiTransport {
+	return &MultiTransport{Transports: transports}
+}
+
+// Scan runs on all the adapters, and fails only if all of them do
+func (t *MultiTransport) Scan(ctx context.Context, handler func(Advertisement)) error {
+	var handlerMu sync.Mutex
+	errs := make([]error, len(t.Transports))
+
+	var wg sync.WaitGroup
+	for i, transport := range t.Transports {
+		wg.Add(1)
+		go func(i int, transport Transport) {
+			defer wg.Done()
+			errs[i] = transport.Scan(ctx, func(a Advertisement) {
+				t.recordRssi(a.Address, i, a.RSSI)
+
+				// callers expect advertisements one at a time
+				handlerMu.Lock()
+				defer handlerMu.Unlock()
+				handler(a)
+			})
+		}(i, transport)
+	}
+	wg.Wait()
+
+	failed := 0
+	var lastErr error
+	for i, err := range errs {
+		switch errors.Cause(err) {
+		case nil:
+		case context.DeadlineExceeded:
+		case context.Canceled:
+		default:
+			log.Errorf("scan failed on adapter #%d: %s", i, err)
+			t.recordFailure(i, err)
+			failed++
+			lastErr = err
+			continue
+		}
+		t.recordSuccess(i)
+	}
+	if failed > 0 && failed == len(t.Transports) {
+		return classify(ErrAdapterDown, errors.Wrap(lastErr, "scan failed on all adapters"))
+	}
+	return ctx.Err()
+}
+
+// Connect tries the adapters from the best to the worst, see Route
+func (t *MultiTransport) Connect(ctx context.Context, addr string) (Conn, error) {
+	route := t.Route(addr)
+
+	lastErr := errors.New("no adapters to connect through")
+	for attempt, i := range route {
+		attemptCtx, cancel := splitDeadline(ctx, len(route)-attempt)
+		conn, err := t.Transports[i].Connect(attemptCtx, addr)
+		cancel()
+		if err == nil {
+			t.recordSuccess(i)
+			return conn, nil
+		}
+
+		lastErr = err
+		if adapterFailure(err) {
+			t.recordFailure(i, err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		log.Warnf("failed to connect to %s on adapter #%d, failing over: %s", addr, i, err)
+	}
+
+	return nil, lastErr
+}
+
+// Route returns adapter indexes in the order they should be tried for the device:
+// healthy adapters before failing ones, then the strongest signal first
+func (t *MultiTransport) Route(addr string) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rssi := t.rssi[strings.ToUpper(addr)]
+	route := make([]int, len(t.Transports))
+	for i := range route {
+		route[i] = i
+	}
+	sort.SliceStable(route, func(a, b int) bool {
+		ia, ib := route[a], route[b]
+		if t.failures[ia] != t.failures[ib] {
+			return t.failures[ia] < t.failures[ib]
+		}
+		rssiA, okA := rssi[ia]
+		rssiB, okB := rssi[ib]
+		if okA != okB {
+			return okA
+		}
+		return rssiA > rssiB
+	})
+	return route
+}
+
+func (t *MultiTransport) recordRssi(addr string, i int, rssi int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.rssi == nil {
+		t.rssi = map[string]map[int]int{}
+	}
+	addr = strings.ToUpper(addr)
+	if t.rssi[addr] == nil {
+		t.rssi[addr] = map[int]int{}
+	}
+	t.rssi[addr][i] = rssi
+}
+
+func (t *MultiTransport) recordFailure(i int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failures == nil {
+		t.failures = map[int]int{}
+	}
+	t.failures[i]++
+	log.Debugf("adapter #%d failed %d time(s) in a row: %s", i, t.failures[i], err)
+}
+
+// whether the adapter is to blame, rather than the device being out of its range, other reads keeping
+// the adapter busy or the caller giving up
+func adapterFailure(err error) bool {
+	return !errors.Is(err, ErrDeviceNotFound) && !errors.Is(err, ErrAdapterBusy) && !errors.Is(err, context.Canceled)
+}
+
+func (t *MultiTransport) recordSuccess(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, i)
+}
+
+// gives an attempt its fair share of the time left until ctx deadline
+func splitDeadline(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || attemptsLeft <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(attemptsLeft))
+}
@@ -0,0 +1,55 @@
+package waveplus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/waveplustest"
+)
+
+func TestMultiTransportFailsOver(t *testing.T) {
+	near := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	near.FailConnects(errors.New("connection refused"))
+	far := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	transport := waveplus.NewMultiTransport(waveplustest.NewTransport(near), waveplustest.NewTransport(far))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := transport.Connect(ctx, wavePlusAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_ = conn.Close()
+
+	if route := transport.Route(wavePlusAddr); route[0] != 1 {
+		t.Errorf("expected the failing adapter last, got route %v", route)
+	}
+}
+
+func TestMultiTransportIgnoresDevicesOutOfRange(t *testing.T) {
+	const otherAddr = "00:11:22:33:44:66"
+	transport := waveplus.NewMultiTransport(
+		waveplustest.NewTransport(waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)),
+		waveplustest.NewTransport(
+			waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues),
+			waveplustest.NewWavePlus(2930000002, otherAddr, testValues),
+		),
+	)
+
+	// only the second adapter hears the other sensor
+	ctx, cancel := context.WithTimeout(context.Background(), 40*time.Millisecond)
+	defer cancel()
+	conn, err := transport.Connect(ctx, otherAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_ = conn.Close()
+
+	if route := transport.Route(wavePlusAddr); route[0] != 0 {
+		t.Errorf("expected the first adapter to stay first, got route %v", route)
+	}
+}
@@ -0,0 +1,129 @@
+package waveplus
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alepar/airthings/airthings"
+)
+
+// RetryPolicy decides whether, and how soon, a failed BLE operation is attempted again
+type RetryPolicy struct {
+	// including the first one, at least one attempt is always made
+	MaxAttempts int
+
+	// before the second attempt, doubled for every following one
+	BaseDelay time.Duration
+
+	// caps the doubling, zero means no cap
+	MaxDelay time.Duration
+
+	// fraction of every delay that is randomized, from 0 to 1,
+	// so that retries of several sensors do not stay in lockstep
+	Jitter float64
+
+	// nil means every error is retried, except those marked with Permanent
+	Retryable func(err error) bool
+
+	// called before sleeping ahead of the next attempt, for logging and metrics
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// legacy behaviour: a fixed number of attempts with a fixed self-pacing interval in an attempt to fix freezes
+func fixedRetryPolicy(attempts int, delay time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: attempts,
+		BaseDelay:   delay,
+		MaxDelay:    delay,
+	}
+}
+
+// Do calls op until it succeeds, returns a non-retryable error, attempts run out or ctx is done
+func (policy *RetryPolicy) Do(ctx context.Context, name string, op func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil {
+			return nil
+		}
+		if !policy.retryable(err) {
+			return errors.Wrapf(err, "%s failed with non-retryable error", name)
+		}
+		if attempt >= policy.MaxAttempts {
+			return errors.Wrapf(err, "all retries to %s failed", name)
+		}
+
+		delay := policy.Delay(attempt)
+		log.Errorf("retrying error in %s (attempt %d/%d) in %s: %s", name, attempt, policy.MaxAttempts, delay, err)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, delay)
+		}
+		// other sensors can be read meanwhile
+		if err := airthings.WhileIdle(ctx, func() error { return sleepContext(ctx, delay) }); err != nil {
+			return errors.Wrapf(err, "%s cancelled", name)
+		}
+	}
+}
+
+// Delay returns how long to wait after the given failed attempt, counting from 1
+func (policy *RetryPolicy) Delay(attempt int) time.Duration {
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay == 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	if policy.Jitter > 0 {
+		jitter := time.Duration(policy.Jitter * float64(delay))
+		delay += time.Duration(randFloat64()*float64(2*jitter)) - jitter
+	}
+	return delay
+}
+
+func (policy *RetryPolicy) retryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return true
+}
+
+// Permanent marks the error as not worth retrying, whatever the policy
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randFloat64() float64 {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return jitterRand.Float64()
+}
@@ -0,0 +1,105 @@
+package waveplus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/waveplustest"
+)
+
+const (
+	wavePlusSerial = 2930123456
+	wavePlusAddr   = "00:11:22:33:44:55"
+)
+
+var testValues = airthings.SensorValues{
+	Humidity:    45.5,
+	RadonShort:  60,
+	RadonLong:   54,
+	Temperature: 21.37,
+	AtmPressure: 1002.5,
+	Co2Level:    612,
+	VocLevel:    87,
+}
+
+func newTestSensor(device *waveplustest.Device, maxAttempts int) *waveplus.BleSensor {
+	sensor := waveplus.NewSensor("2930123456", device.Address)
+	sensor.ScanDuration = time.Second
+	sensor.Transport = waveplustest.NewTransport(device)
+	sensor.RetryPolicy = &waveplus.RetryPolicy{MaxAttempts: maxAttempts, BaseDelay: time.Millisecond}
+	return sensor
+}
+
+func TestRetryPolicyRetriesUntilSuccess(t *testing.T) {
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.FailConnects(errors.New("connection refused"), errors.New("connection refused"))
+	sensor := newTestSensor(device, 5)
+
+	if _, err := sensor.ReceiveContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if connects := device.Connects(); connects != 3 {
+		t.Errorf("expected 3 connects, got %d", connects)
+	}
+}
+
+func TestRetryPolicyStopsAtMaxAttempts(t *testing.T) {
+	refused := errors.New("connection refused")
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.FailConnects(refused, refused, refused, refused, refused)
+	sensor := newTestSensor(device, 3)
+
+	_, err := sensor.ReceiveContext(context.Background())
+	if !errors.Is(err, refused) {
+		t.Fatalf("expected the connect error, got %v", err)
+	}
+	if connects := device.Connects(); connects != 3 {
+		t.Errorf("expected 3 connects, got %d", connects)
+	}
+}
+
+func TestRetryPolicyStopsAtPermanent(t *testing.T) {
+	unsupported := errors.New("unsupported")
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.FailConnects(waveplus.Permanent(unsupported), errors.New("connection refused"))
+	sensor := newTestSensor(device, 5)
+
+	_, err := sensor.ReceiveContext(context.Background())
+	if !errors.Is(err, unsupported) {
+		t.Fatalf("expected the permanent error, got %v", err)
+	}
+	if connects := device.Connects(); connects != 1 {
+		t.Errorf("expected 1 connect, got %d", connects)
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	refused := errors.New("connection refused")
+	device := waveplustest.NewWavePlus(wavePlusSerial, wavePlusAddr, testValues)
+	device.FailConnects(refused, refused)
+	sensor := newTestSensor(device, 5)
+	sensor.RetryPolicy.Retryable = func(err error) bool {
+		return !errors.Is(err, refused)
+	}
+
+	if _, err := sensor.ReceiveContext(context.Background()); !errors.Is(err, refused) {
+		t.Fatalf("expected the connect error, got %v", err)
+	}
+	if connects := device.Connects(); connects != 1 {
+		t.Errorf("expected 1 connect, got %d", connects)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &waveplus.RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay := policy.Delay(attempt + 1); delay != want {
+			t.Errorf("expected %s after attempt %d, got %s", want, attempt+1, delay)
+		}
+	}
+}
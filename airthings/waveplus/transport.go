@@ -0,0 +1,224 @@
+package waveplus
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Transport is the part of a BLE stack needed to find and read the sensors
+type Transport interface {
+
+	// reports every advertisement until ctx is done, then returns ctx.Err()
+	Scan(ctx context.Context, handler func(Advertisement)) error
+
+	// waits for the device to advertise and connects to it
+	Connect(ctx context.Context, addr string) (Conn, error)
+}
+
+// Advertisement is what a scan learns about a nearby device
+type Advertisement struct {
+	Address          string
+	RSSI             int
+	Connectable      bool
+	ManufacturerData []byte
+}
+
+// Conn is an established connection to a device
+type Conn interface {
+	DiscoverServices(filter []ble.UUID) ([]*ble.Service, error)
+	DiscoverCharacteristics(filter []ble.UUID, service *ble.Service) ([]*ble.Characteristic, error)
+	DiscoverDescriptors(filter []ble.UUID, c *ble.Characteristic) ([]*ble.Descriptor, error)
+	ReadCharacteristic(c *ble.Characteristic) ([]byte, error)
+	WriteCharacteristic(c *ble.Characteristic, value []byte, noRsp bool) error
+
+	// the characteristic descriptors have to be discovered first
+	Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error
+	Unsubscribe(c *ble.Characteristic, ind bool) error
+
+	// signal strength the device was heard at when connecting, units: dBm
+	ReadRSSI() int
+
+	// disconnects and waits until the device is gone
+	Close() error
+}
+
+// BleTransport talks to the sensors through a go-ble device
+type BleTransport struct {
+	// nil means the go-ble default device, see ble.SetDefaultDevice
+	Device ble.Device
+}
+
+func NewBleTransport(device ble.Device) *BleTransport {
+	return &BleTransport{Device: device}
+}
+
+func (t *BleTransport) Scan(ctx context.Context, handler func(Advertisement)) error {
+	h := func(a ble.Advertisement) {
+		handler(Advertisement{
+			Address:          a.Addr().String(),
+			RSSI:             a.RSSI(),
+			Connectable:      a.Connectable(),
+			ManufacturerData: a.ManufacturerData(),
+		})
+	}
+
+	release, err := acquire(ctx, t.Device)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if t.Device == nil {
+		return ble.Scan(ctx, false, h, nil)
+	}
+	return t.Device.Scan(ctx, false, h)
+}
+
+func (t *BleTransport) Connect(ctx context.Context, addr string) (Conn, error) {
+	filter := func(a ble.Advertisement) bool {
+		return strings.ToUpper(a.Addr().String()) == strings.ToUpper(addr)
+	}
+
+	scan, dial := ble.Scan, ble.Dial
+	if t.Device != nil {
+		scan = func(ctx context.Context, allowDup bool, h ble.AdvHandler, f ble.AdvFilter) error {
+			return t.Device.Scan(ctx, allowDup, func(a ble.Advertisement) {
+				if f(a) {
+					h(a)
+				}
+			})
+		}
+		dial = t.Device.Dial
+	}
+
+	release, err := acquire(ctx, t.Device)
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			// no time was left to look for the device
+			return nil, classify(ErrAdapterBusy, errors.Wrap(err, "adapter busy"))
+		}
+		return nil, errors.Wrap(err, "adapter busy")
+	}
+	cln, rssi, err := connect(ctx, scan, dial, filter)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	// Normally, the connection is disconnected by us after our exploration.
+	// However, it can be asynchronously disconnected by the remote peripheral.
+	// So we wait(detect) the disconnection in the go routine.
+	done := make(chan struct{})
+	go func() {
+		<-cln.Disconnected()
+		log.Debugf("device disconnected")
+		close(done)
+	}()
+
+	return &bleConn{Client: cln, rssi: rssi, done: done}, nil
+}
+
+// same as ble.Connect, but on the given scan and dial functions, with the failures classified,
+// and returning the RSSI of the advertisement the device was found by
+func connect(
+	ctx context.Context,
+	scan func(context.Context, bool, ble.AdvHandler, ble.AdvFilter) error,
+	dial func(context.Context, ble.Addr) (ble.Client, error),
+	filter ble.AdvFilter,
+) (ble.Client, int, error) {
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan ble.Advertisement, 1)
+	err := scan(scanCtx, false, func(a ble.Advertisement) {
+		select {
+		case found <- a:
+		default:
+		}
+		cancel()
+	}, filter)
+
+	select {
+	case a := <-found:
+		cln, err := dial(ctx, a.Addr())
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, 0, classify(ErrConnectTimeout, errors.Wrap(err, "can't dial"))
+			}
+			return nil, 0, errors.Wrap(err, "can't dial")
+		}
+		return cln, a.RSSI(), nil
+	default:
+		switch errors.Cause(err) {
+		case nil:
+			return nil, 0, ErrDeviceNotFound
+		case context.DeadlineExceeded:
+			return nil, 0, classify(ErrDeviceNotFound, errors.Wrap(err, "can't scan"))
+		case context.Canceled:
+			return nil, 0, errors.Wrap(err, "can't scan")
+		default:
+			return nil, 0, classify(ErrAdapterDown, errors.Wrap(err, "can't scan"))
+		}
+	}
+}
+
+var (
+	adaptersMu sync.Mutex
+	// one slot per go-ble device, nil being the default one
+	adapters = map[ble.Device]chan struct{}{}
+)
+
+// waits for the device to be free: go-ble keeps a single advertisement handler and a single set of
+// connection parameters per device, so concurrent scans and dials would overwrite each other
+func acquire(ctx context.Context, device ble.Device) (func(), error) {
+	adaptersMu.Lock()
+	slot, ok := adapters[device]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		adapters[device] = slot
+	}
+	adaptersMu.Unlock()
+
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type bleConn struct {
+	ble.Client
+	rssi int
+	done chan struct{}
+}
+
+// the linux go-ble client does not implement ReadRSSI, it always returns 0
+func (conn *bleConn) ReadRSSI() int {
+	return conn.rssi
+}
+
+func (conn *bleConn) Close() error {
+	err := conn.CancelConnection()
+	<-conn.done
+	return errors.Wrap(err, "failed to cancel connection")
+}
+
+func (scanner *BleScanner) transport() Transport {
+	if scanner.Transport == nil {
+		return &BleTransport{}
+	}
+	return scanner.Transport
+}
+
+func (sensor *BleSensor) transport() Transport {
+	if sensor.Transport == nil {
+		return &BleTransport{}
+	}
+	return sensor.Transport
+}
@@ -0,0 +1,105 @@
+package waveplus_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings/waveplus"
+)
+
+// stubDevice is the part of a go-ble device BleTransport uses, the rest panics
+type stubDevice struct {
+	ble.Device
+	advertisements []ble.Advertisement
+}
+
+func (d *stubDevice) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler) error {
+	for _, a := range d.advertisements {
+		h(a)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (d *stubDevice) Dial(ctx context.Context, addr ble.Addr) (ble.Client, error) {
+	return &stubClient{disconnected: make(chan struct{})}, nil
+}
+
+// ReadRSSI is left out, the linux client does not implement it either
+type stubClient struct {
+	ble.Client
+	disconnected chan struct{}
+}
+
+func (c *stubClient) Disconnected() <-chan struct{} {
+	return c.disconnected
+}
+
+func (c *stubClient) CancelConnection() error {
+	close(c.disconnected)
+	return nil
+}
+
+type stubAdvertisement struct {
+	ble.Advertisement
+	addr string
+	rssi int
+}
+
+func (a *stubAdvertisement) Addr() ble.Addr {
+	return ble.NewAddr(a.addr)
+}
+
+func (a *stubAdvertisement) RSSI() int {
+	return a.rssi
+}
+
+func TestConnectReportsAdvertisementRssi(t *testing.T) {
+	transport := waveplus.NewBleTransport(&stubDevice{advertisements: []ble.Advertisement{
+		&stubAdvertisement{addr: "00:11:22:33:44:01", rssi: -90},
+		&stubAdvertisement{addr: strings.ToLower(wavePlusAddr), rssi: -67},
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := transport.Connect(ctx, wavePlusAddr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	if rssi := conn.ReadRSSI(); rssi != -67 {
+		t.Errorf("expected the RSSI of the advertisement connected on -67, got %d", rssi)
+	}
+}
+
+func TestConnectWaitingForBusyAdapter(t *testing.T) {
+	// scans until its deadline, the device never advertises
+	device := &stubDevice{}
+	transport := waveplus.NewBleTransport(device)
+
+	scanning := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := transport.Connect(ctx, "00:11:22:33:44:01")
+		scanning <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := transport.Connect(ctx, wavePlusAddr)
+	if !errors.Is(err, waveplus.ErrAdapterBusy) || errors.Is(err, waveplus.ErrDeviceNotFound) {
+		t.Errorf("expected ErrAdapterBusy, got %v", err)
+	}
+
+	if err := <-scanning; !errors.Is(err, waveplus.ErrDeviceNotFound) {
+		t.Errorf("expected ErrDeviceNotFound for the device that did not advertise, got %v", err)
+	}
+}
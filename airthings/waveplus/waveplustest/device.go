@@ -0,0 +1,260 @@
+package waveplustest
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/frame"
+)
+
+// Device emulates the advertisement and the GATT profile of one sensor
+type Device struct {
+	Address          string
+	RSSI             int
+	ManufacturerData []byte
+	Services         []*ble.Service
+
+	mu         sync.Mutex
+	silent     bool
+	connects   int
+	connectErr []error
+	readErr    []error
+	commands   map[uint8]CommandHandler
+
+	// units: mV
+	battery uint16
+}
+
+// CommandHandler answers a command written to a characteristic with the notifications the device sends back
+type CommandHandler func(cmd []byte) [][]byte
+
+// NewDevice builds a device advertising the serial number the way Airthings sensors do
+func NewDevice(serialNr uint32, addr string, services ...*ble.Service) *Device {
+	manufacturerData := []byte{0x34, 0x03, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(manufacturerData[2:], serialNr)
+
+	// handles are assigned sequentially, like a GATT server would
+	handle := uint16(1)
+	for _, service := range services {
+		service.Handle = handle
+		handle++
+		for _, characteristic := range service.Characteristics {
+			characteristic.Handle = handle
+			characteristic.ValueHandle = handle + 1
+			characteristic.EndHandle = handle + 1
+			handle += 2
+			if characteristic.Property&(ble.CharNotify|ble.CharIndicate) != 0 {
+				characteristic.CCCD = &ble.Descriptor{UUID: ble.ClientCharacteristicConfigUUID, Handle: handle}
+				characteristic.Descriptors = append(characteristic.Descriptors, characteristic.CCCD)
+				characteristic.EndHandle = handle
+				handle++
+			}
+		}
+		service.EndHandle = handle - 1
+	}
+
+	return &Device{
+		Address:          addr,
+		RSSI:             -60,
+		ManufacturerData: manufacturerData,
+		Services:         services,
+	}
+}
+
+// NewWavePlus emulates a Wave Plus reporting the given values
+func NewWavePlus(serialNr uint32, addr string, values airthings.SensorValues) *Device {
+	service := ble.NewService(ble.MustParse("b42e1c08ade711e489d3123b93f75cba"))
+	characteristic := service.NewCharacteristic(ble.MustParse("b42e2a68ade711e489d3123b93f75cba"))
+	characteristic.Property = ble.CharRead
+	characteristic.Value = EncodeWavePlus(values)
+	command := service.NewCharacteristic(ble.MustParse("b42e2d06ade711e489d3123b93f75cba"))
+	command.Property = ble.CharRead | ble.CharWrite | ble.CharNotify
+
+	device := NewDevice(serialNr, addr, service, NewDeviceInformation(airthings.DeviceInfo{
+		Manufacturer: "Airthings AS",
+		Model:        "2930",
+		Firmware:     "G-BLE-1.5.3-master+0",
+		Hardware:     "REV A",
+	}))
+	device.battery = 3000
+	device.HandleCommand(frame.CommandState, device.state)
+	return device
+}
+
+// NewDeviceInformation builds the standard Device Information service reporting the given info
+func NewDeviceInformation(info airthings.DeviceInfo) *ble.Service {
+	service := ble.NewService(ble.UUID16(0x180a))
+	for _, c := range []struct {
+		uuid  uint16
+		value string
+	}{
+		{0x2a29, info.Manufacturer},
+		{0x2a24, info.Model},
+		{0x2a26, info.Firmware},
+		{0x2a27, info.Hardware},
+	} {
+		characteristic := service.NewCharacteristic(ble.UUID16(c.uuid))
+		characteristic.Property = ble.CharRead
+		characteristic.Value = []byte(c.value)
+	}
+	return service
+}
+
+// HandleCommand makes writes of commands starting with the given byte answered by the handler
+func (d *Device) HandleCommand(command uint8, handler CommandHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.commands == nil {
+		d.commands = map[uint8]CommandHandler{}
+	}
+	d.commands[command] = handler
+}
+
+// SetBatteryVoltage changes the battery voltage reported in the response to frame.CommandState, units: V
+func (d *Device) SetBatteryVoltage(voltage float32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.battery = uint16(voltage*1000 + 0.5)
+}
+
+// called with d.mu held
+func (d *Device) state(cmd []byte) [][]byte {
+	response := make([]byte, 30)
+	response[0] = frame.CommandState
+	binary.LittleEndian.PutUint16(response[18:], d.battery)
+	return [][]byte{response}
+}
+
+// EncodeWavePlus builds the frame a Wave Plus would report for the given values
+func EncodeWavePlus(values airthings.SensorValues) []byte {
+	buf := make([]byte, frame.Length)
+	buf[0] = frame.Version
+	buf[1] = uint8(values.Humidity * 2)
+	binary.LittleEndian.PutUint16(buf[4:], values.RadonShort)
+	binary.LittleEndian.PutUint16(buf[6:], values.RadonLong)
+	binary.LittleEndian.PutUint16(buf[8:], uint16(values.Temperature*100))
+	binary.LittleEndian.PutUint16(buf[10:], uint16(values.AtmPressure*50))
+	binary.LittleEndian.PutUint16(buf[12:], uint16(values.Co2Level))
+	binary.LittleEndian.PutUint16(buf[14:], uint16(values.VocLevel))
+	return buf
+}
+
+// SetValue changes what reading the characteristic returns
+func (d *Device) SetValue(uuid ble.UUID, value []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if characteristic := d.find(func(c *ble.Characteristic) bool { return c.UUID.Equal(uuid) }); characteristic != nil {
+		characteristic.Value = value
+	}
+}
+
+// SetSilent stops or resumes advertising, as if the device went out of range
+func (d *Device) SetSilent(silent bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.silent = silent
+}
+
+// FailConnects makes the next connection attempts fail with the given errors, one per attempt
+func (d *Device) FailConnects(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connectErr = append(d.connectErr, errs...)
+}
+
+// FailReads makes the next characteristic reads fail with the given errors, one per read
+func (d *Device) FailReads(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.readErr = append(d.readErr, errs...)
+}
+
+// Connects returns the number of connection attempts so far, failed ones included
+func (d *Device) Connects() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connects
+}
+
+func (d *Device) advertisement() waveplus.Advertisement {
+	return waveplus.Advertisement{
+		Address:          d.Address,
+		RSSI:             d.RSSI,
+		Connectable:      true,
+		ManufacturerData: d.ManufacturerData,
+	}
+}
+
+func (d *Device) isSilent() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.silent
+}
+
+func (d *Device) connect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.connects++
+	if len(d.connectErr) > 0 {
+		err := d.connectErr[0]
+		d.connectErr = d.connectErr[1:]
+		return err
+	}
+	return nil
+}
+
+func (d *Device) read(valueHandle uint16) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.readErr) > 0 {
+		err := d.readErr[0]
+		d.readErr = d.readErr[1:]
+		return nil, err
+	}
+
+	characteristic := d.find(func(c *ble.Characteristic) bool { return c.ValueHandle == valueHandle })
+	if characteristic == nil {
+		return nil, errors.Errorf("no characteristic with value handle 0x%04x", valueHandle)
+	}
+	return append([]byte{}, characteristic.Value...), nil
+}
+
+// returns the notifications sent back on the characteristic
+func (d *Device) write(valueHandle uint16, value []byte) ([][]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	characteristic := d.find(func(c *ble.Characteristic) bool { return c.ValueHandle == valueHandle })
+	if characteristic == nil {
+		return nil, errors.Errorf("no characteristic with value handle 0x%04x", valueHandle)
+	}
+	if characteristic.Property&(ble.CharWrite|ble.CharWriteNR) == 0 {
+		return nil, errors.Errorf("characteristic %s is not writable", characteristic.UUID)
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+	handler, ok := d.commands[value[0]]
+	if !ok {
+		return nil, nil
+	}
+	return handler(value), nil
+}
+
+func (d *Device) find(match func(c *ble.Characteristic) bool) *ble.Characteristic {
+	for _, service := range d.Services {
+		for _, characteristic := range service.Characteristics {
+			if match(characteristic) {
+				return characteristic
+			}
+		}
+	}
+	return nil
+}
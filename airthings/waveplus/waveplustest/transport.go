@@ -0,0 +1,222 @@
+// Package waveplustest provides an in-memory waveplus.Transport, so that the library can be exercised without a radio.
+package waveplustest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-ble/ble"
+	"github.com/pkg/errors"
+
+	"github.com/alepar/airthings/airthings/waveplus"
+)
+
+// Transport emulates a BLE adapter that sees the added devices
+type Transport struct {
+	mu      sync.Mutex
+	devices []*Device
+}
+
+func NewTransport(devices ...*Device) *Transport {
+	return &Transport{devices: devices}
+}
+
+func (t *Transport) Add(device *Device) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.devices = append(t.devices, device)
+}
+
+func (t *Transport) Scan(ctx context.Context, handler func(waveplus.Advertisement)) error {
+	for _, device := range t.advertising() {
+		handler(device.advertisement())
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (t *Transport) Connect(ctx context.Context, addr string) (waveplus.Conn, error) {
+	for _, device := range t.advertising() {
+		if strings.EqualFold(device.Address, addr) {
+			if err := device.connect(); err != nil {
+				return nil, errors.Wrap(err, "can't dial")
+			}
+			return &conn{device: device}, nil
+		}
+	}
+
+	// the real thing keeps scanning for the device until it gives up
+	<-ctx.Done()
+	if ctx.Err() == context.Canceled {
+		return nil, errors.Wrap(ctx.Err(), "can't scan")
+	}
+	return nil, errors.Wrapf(waveplus.ErrDeviceNotFound, "%s did not advertise", addr)
+}
+
+func (t *Transport) advertising() []*Device {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var devices []*Device
+	for _, device := range t.devices {
+		if !device.isSilent() {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+type conn struct {
+	device *Device
+
+	mu            sync.Mutex
+	closed        bool
+	subscriptions map[uint16]ble.NotificationHandler
+}
+
+func (c *conn) DiscoverServices(filter []ble.UUID) ([]*ble.Service, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	var services []*ble.Service
+	for _, service := range c.device.Services {
+		if filter == nil || ble.Contains(filter, service.UUID) {
+			services = append(services, &ble.Service{
+				UUID:      service.UUID,
+				Handle:    service.Handle,
+				EndHandle: service.EndHandle,
+			})
+		}
+	}
+	return services, nil
+}
+
+func (c *conn) DiscoverCharacteristics(filter []ble.UUID, s *ble.Service) ([]*ble.Characteristic, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	var characteristics []*ble.Characteristic
+	for _, service := range c.device.Services {
+		if service.Handle != s.Handle {
+			continue
+		}
+		for _, characteristic := range service.Characteristics {
+			if filter == nil || ble.Contains(filter, characteristic.UUID) {
+				characteristics = append(characteristics, &ble.Characteristic{
+					UUID:        characteristic.UUID,
+					Property:    characteristic.Property,
+					Handle:      characteristic.Handle,
+					ValueHandle: characteristic.ValueHandle,
+					EndHandle:   characteristic.EndHandle,
+				})
+			}
+		}
+	}
+	return characteristics, nil
+}
+
+func (c *conn) DiscoverDescriptors(filter []ble.UUID, characteristic *ble.Characteristic) ([]*ble.Descriptor, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	original := c.device.find(func(other *ble.Characteristic) bool { return other.ValueHandle == characteristic.ValueHandle })
+	if original == nil {
+		return nil, errors.Errorf("no characteristic with value handle 0x%04x", characteristic.ValueHandle)
+	}
+
+	var descriptors []*ble.Descriptor
+	for _, descriptor := range original.Descriptors {
+		if filter == nil || ble.Contains(filter, descriptor.UUID) {
+			d := &ble.Descriptor{UUID: descriptor.UUID, Handle: descriptor.Handle}
+			if original.CCCD != nil && descriptor.Handle == original.CCCD.Handle {
+				characteristic.CCCD = d
+			}
+			descriptors = append(descriptors, d)
+		}
+	}
+	characteristic.Descriptors = descriptors
+	return descriptors, nil
+}
+
+func (c *conn) ReadCharacteristic(characteristic *ble.Characteristic) ([]byte, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return c.device.read(characteristic.ValueHandle)
+}
+
+func (c *conn) WriteCharacteristic(characteristic *ble.Characteristic, value []byte, noRsp bool) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	notifications, err := c.device.write(characteristic.ValueHandle, value)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	handler := c.subscriptions[characteristic.ValueHandle]
+	c.mu.Unlock()
+	if handler != nil && len(notifications) > 0 {
+		// notifications arrive after the write completes, like they would over the air
+		go func() {
+			for _, notification := range notifications {
+				handler(notification)
+			}
+		}()
+	}
+	return nil
+}
+
+func (c *conn) Subscribe(characteristic *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	if characteristic.CCCD == nil {
+		return errors.New("CCCD not found")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscriptions == nil {
+		c.subscriptions = map[uint16]ble.NotificationHandler{}
+	}
+	c.subscriptions[characteristic.ValueHandle] = h
+	return nil
+}
+
+func (c *conn) Unsubscribe(characteristic *ble.Characteristic, ind bool) error {
+	if err := c.check(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subscriptions, characteristic.ValueHandle)
+	return nil
+}
+
+func (c *conn) ReadRSSI() int {
+	return c.device.RSSI
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *conn) check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New("connection closed")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Converts the readings the exporter appended to its file sinks into OpenMetrics, to fill the gaps
+// in Prometheus after it could not scrape the exporter:
+//
+//	waveplus_backfill -since 2021-03-01T12:00:00Z -until 2021-03-02T08:00:00Z -out backfill.om readings.jsonl
+//	promtool tsdb create-blocks-from openmetrics backfill.om /prometheus/data
+//
+// The series are named and labelled like the exporter publishes them, with the sensor labels
+// configured when the readings were taken.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alepar/airthings/airthings/metrics"
+	"github.com/alepar/airthings/airthings/store"
+)
+
+// CLI args
+var (
+	since = flag.String("since", "", "oldest reading to convert, either a duration back from now or an RFC 3339 time; all of them if empty")
+	until = flag.String("until", "", "newest reading to convert, either a duration back from now or an RFC 3339 time; all of them if empty")
+	out   = flag.String("out", "-", "file to write the OpenMetrics to, - for stdout")
+)
+
+func init() {
+	formatter := &log.TextFormatter{
+		FullTimestamp: true,
+	}
+	log.SetFormatter(formatter)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	now := time.Now()
+	from, err := parseTime(*since, now)
+	if err != nil {
+		log.Fatalf("invalid -since %q: %s", *since, err)
+	}
+	to, err := parseTime(*until, now)
+	if err != nil {
+		log.Fatalf("invalid -until %q: %s", *until, err)
+	}
+
+	var readings []metrics.LabelledReading
+	for _, path := range flag.Args() {
+		read, err := readFile(path, from, to)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("read %d readings from %s", len(read), path)
+		readings = append(readings, read...)
+	}
+
+	if len(readings) == 0 {
+		log.Warn("no readings in the given time range")
+	}
+	if err := write(readings); err != nil {
+		log.Fatalf("failed to write %s: %s", *out, err)
+	}
+}
+
+// the readings from the file taken between from and to, either of which can be zero for no limit
+func readFile(path string, from time.Time, to time.Time) ([]metrics.LabelledReading, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open readings")
+	}
+	defer f.Close()
+
+	var readings []metrics.LabelledReading
+	err = store.Read(f, func(record store.Record) error {
+		if (!from.IsZero() && record.Time.Before(from)) || (!to.IsZero() && record.Time.After(to)) {
+			return nil
+		}
+		readings = append(readings, metrics.LabelledReading{Reading: record.Reading(), Labels: record.SensorLabels})
+		return nil
+	})
+	return readings, errors.Wrapf(err, "failed to read %s", path)
+}
+
+func write(readings []metrics.LabelledReading) error {
+	if *out == "-" {
+		return metrics.WriteOpenMetrics(os.Stdout, readings)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := metrics.WriteOpenMetrics(f, readings); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// accepts either a duration back from now, or an absolute time; empty is the zero time
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
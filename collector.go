@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/metrics"
+	"github.com/alepar/airthings/airthings/waveplus"
+)
+
+// sensorCollector publishes the latest state of every sensor, labelled with the sensor metadata from the config.
+// It is an unchecked collector, as the extra labels change with the config.
+type sensorCollector struct {
+	mu sync.Mutex
+
+	// all the series have the labels of all the configured sensors
+	labelNames  []string
+	labels      map[string]metrics.SensorLabels
+	diagnostics bool
+
+	sensors map[string]*sensorState
+}
+
+type sensorState struct {
+	// zero when offline
+	values airthings.SensorValues
+	raw    []airthings.RawField
+
+	// units: dBm, zero when offline or unknown
+	rssi int
+
+	// kept while offline, nil until read
+	info    *airthings.DeviceInfo
+	address string
+
+	lastSuccess time.Time
+	attempts    uint64
+	successes   uint64
+	// by error class
+	failures map[string]uint64
+}
+
+func newSensorCollector() *sensorCollector {
+	return &sensorCollector{
+		labelNames: metrics.LabelNames(nil),
+		labels:     map[string]metrics.SensorLabels{},
+		sensors:    map[string]*sensorState{},
+	}
+}
+
+// applies the sensor labels and the diagnostics setting, to the next scrape on
+func (c *sensorCollector) configure(cfg *config) {
+	labels := map[string]metrics.SensorLabels{}
+	for _, sensor := range cfg.Sensors {
+		labels[sensor.Serial] = sensor.SensorLabels
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.labelNames = metrics.LabelNames(cfg.sensorLabels())
+	c.labels = labels
+	c.diagnostics = cfg.DiagnosticMetrics
+}
+
+func (c *sensorCollector) update(reading airthings.Reading) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.state(reading.SerialNumber)
+	state.values = reading.SensorValues
+	state.raw = reading.RawFields
+	state.rssi = reading.RSSI
+	state.address = reading.Address
+	if reading.Info != nil {
+		state.info = reading.Info
+	}
+	state.lastSuccess = reading.Time
+	state.successes++
+}
+
+// counts a connection attempt, retries included
+func (c *sensorCollector) attempted(serialNr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state(serialNr).attempts++
+}
+
+// counts a read that failed, after all the retries
+func (c *sensorCollector) failed(serialNr string, class string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state(serialNr).failures[class]++
+}
+
+// stops reporting the last values as current, the device information stays
+func (c *sensorCollector) offline(serialNr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := c.state(serialNr)
+	state.values = airthings.SensorValues{}
+	state.raw = nil
+	state.rssi = 0
+}
+
+// drops the sensors that are not known anymore, e.g. once they expired from the cache
+func (c *sensorCollector) retain(known map[string]airthings.Sensor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for serialNr := range c.sensors {
+		if _, ok := known[serialNr]; !ok {
+			delete(c.sensors, serialNr)
+		}
+	}
+}
+
+func (c *sensorCollector) state(serialNr string) *sensorState {
+	state, ok := c.sensors[serialNr]
+	if !ok {
+		state = &sensorState{failures: map[string]uint64{}}
+		c.sensors[serialNr] = state
+	}
+	return state
+}
+
+// no descriptions, which makes the collector unchecked
+func (c *sensorCollector) Describe(chan<- *prometheus.Desc) {
+}
+
+func (c *sensorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	names := c.labelNames
+	var measurementDescs []*prometheus.Desc
+	for _, m := range metrics.Measurements {
+		measurementDescs = append(measurementDescs, prometheus.NewDesc(m.Name, m.Help, names, nil))
+	}
+	infoDesc := prometheus.NewDesc(
+		"air_sensor_info", "Sensor model and firmware, as reported by the sensor (always 1)",
+		append(append([]string{}, names...), "model", "firmware", "address"), nil,
+	)
+	rawFieldDesc := prometheus.NewDesc(
+		"air_raw_field", "Frame field of unknown meaning, by its index in the frame layout",
+		append(append([]string{}, names...), "index"), nil,
+	)
+	rssiDesc := prometheus.NewDesc("air_rssi_dbm", "Signal strength of the last connection to the sensor (units: dBm)", names, nil)
+	lastSuccessDesc := prometheus.NewDesc(
+		"air_last_success_timestamp_seconds", "When the sensor was last read successfully (units: seconds since epoch)", names, nil,
+	)
+	attemptsDesc := prometheus.NewDesc("air_read_attempts_total", "Connection attempts to the sensor, retries included", names, nil)
+	successesDesc := prometheus.NewDesc("air_read_successes_total", "Successful reads of the sensor", names, nil)
+	failuresDesc := prometheus.NewDesc(
+		"air_read_failures_total", "Reads of the sensor that failed after all the retries, by error class",
+		append(append([]string{}, names...), "class"), nil,
+	)
+
+	for serialNr, state := range c.sensors {
+		values := c.labels[serialNr].LabelValues(serialNr, names)
+
+		// only valid measurements, as not every model measures everything and sensors report sentinels while warming up
+		for i, m := range metrics.Measurements {
+			if state.values.Has(m.Field) {
+				ch <- prometheus.MustNewConstMetric(measurementDescs[i], prometheus.GaugeValue, m.Value(state.values), values...)
+			}
+		}
+
+		if state.info != nil {
+			model := state.info.Model
+			if model == "" {
+				if m, ok := waveplus.ModelBySerialNumber(serialNr); ok {
+					model = m.Name
+				}
+			}
+			ch <- prometheus.MustNewConstMetric(infoDesc, prometheus.GaugeValue, 1,
+				append(append([]string{}, values...), model, state.info.Firmware, state.address)...)
+		}
+
+		if state.rssi != 0 {
+			ch <- prometheus.MustNewConstMetric(rssiDesc, prometheus.GaugeValue, float64(state.rssi), values...)
+		}
+		if !state.lastSuccess.IsZero() {
+			ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue,
+				float64(state.lastSuccess.UnixNano())/1e9, values...)
+		}
+		ch <- prometheus.MustNewConstMetric(attemptsDesc, prometheus.CounterValue, float64(state.attempts), values...)
+		ch <- prometheus.MustNewConstMetric(successesDesc, prometheus.CounterValue, float64(state.successes), values...)
+		for class, failures := range state.failures {
+			ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(failures),
+				append(append([]string{}, values...), class)...)
+		}
+
+		if c.diagnostics {
+			for _, field := range state.raw {
+				ch <- prometheus.MustNewConstMetric(rawFieldDesc, prometheus.GaugeValue, float64(field.Value),
+					append(append([]string{}, values...), strconv.Itoa(field.Index))...)
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/metrics"
+)
+
+// the values of the metric by serial number
+func gather(t *testing.T, c *sensorCollector, name string) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather: %s", err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.Metric {
+			var serialNr string
+			for _, label := range m.Label {
+				if label.GetName() == metrics.SerialNumberLabel {
+					serialNr = label.GetValue()
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				values[serialNr] = m.Gauge.GetValue()
+			case m.Counter != nil:
+				values[serialNr] = m.Counter.GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestCollectorRetainsKnownSensors(t *testing.T) {
+	c := newSensorCollector()
+	for _, serialNr := range []string{"2930000001", "2930000002"} {
+		c.update(airthings.Reading{
+			SerialNumber: serialNr,
+			Time:         time.Now(),
+			SensorValues: airthings.SensorValues{Temperature: 21.5, Fields: airthings.FieldTemperature},
+		})
+	}
+
+	c.retain(map[string]airthings.Sensor{"2930000002": nil})
+
+	if successes := gather(t, c, "air_read_successes_total"); len(successes) != 1 || successes["2930000002"] != 1 {
+		t.Errorf("expected only the known sensor, got %v", successes)
+	}
+	if temperatures := gather(t, c, "air_temperature"); len(temperatures) != 1 {
+		t.Errorf("expected the values of the known sensor only, got %v", temperatures)
+	}
+}
+
+func TestCollectorRssi(t *testing.T) {
+	c := newSensorCollector()
+	// as reported by BleTransport, from the advertisement connected on
+	c.update(airthings.Reading{SerialNumber: "2930000001", Time: time.Now(), RSSI: -67})
+
+	if rssi := gather(t, c, "air_rssi_dbm"); len(rssi) != 1 || rssi["2930000001"] != -67 {
+		t.Errorf("expected the RSSI of the last connection -67, got %v", rssi)
+	}
+
+	c.offline("2930000001")
+	if rssi := gather(t, c, "air_rssi_dbm"); len(rssi) != 0 {
+		t.Errorf("expected no RSSI while offline, got %v", rssi)
+	}
+}
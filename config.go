@@ -0,0 +1,394 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/metrics"
+	"github.com/alepar/airthings/airthings/waveplus"
+)
+
+// flags can also be given as environment variables, e.g. WAVEPLUS_READ_INT for -read-int
+const envPrefix = "WAVEPLUS_"
+
+// config of the exporter: flag defaults, overridden by the config file,
+// overridden by environment variables, overridden by the flags given on the command line
+type config struct {
+	ListenAddress     string         `yaml:"listen_address"`
+	ReadInterval      time.Duration  `yaml:"read_interval"`
+	ScanDuration      time.Duration  `yaml:"scan_duration"`
+	RescanInterval    time.Duration  `yaml:"rescan_interval"`
+	ExpireAfter       time.Duration  `yaml:"expire_after"`
+	ReadConcurrency   int            `yaml:"read_concurrency"`
+	ReadTimeout       time.Duration  `yaml:"read_timeout"`
+	RefreshInterval   time.Duration  `yaml:"refresh_interval"`
+	PollDelay         time.Duration  `yaml:"poll_delay"`
+	PollJitter        time.Duration  `yaml:"poll_jitter"`
+	Retries           retryConfig    `yaml:"retries"`
+	Adapters          []string       `yaml:"adapters"`
+	DiagnosticMetrics bool           `yaml:"diagnostic_metrics"`
+	Debug             bool           `yaml:"debug"`
+	Sensors           []sensorConfig `yaml:"sensors"`
+	Sinks             []sinkConfig   `yaml:"sinks"`
+}
+
+type retryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	Delay       time.Duration `yaml:"delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	Jitter      float64       `yaml:"jitter"`
+}
+
+type sensorConfig struct {
+	Serial string `yaml:"serial"`
+
+	// read without scanning for the sensor, if set
+	Address string `yaml:"address"`
+
+	// name, room, floor, building and extra labels
+	metrics.SensorLabels `yaml:",inline"`
+
+	Calibration calibration `yaml:"calibration"`
+
+	// fixed read interval instead of following the sensor refresh cycle, zero means the latter
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// offsets added to the measured values, in their units
+type calibration struct {
+	Humidity    float32 `yaml:"humidity"`
+	Temperature float32 `yaml:"temperature"`
+	AtmPressure float32 `yaml:"atm_pressure"`
+	Co2Level    float32 `yaml:"co2_level"`
+	VocLevel    float32 `yaml:"voc_level"`
+}
+
+const (
+	sinkLog  = "log"
+	sinkFile = "file"
+)
+
+type sinkConfig struct {
+	// sinkLog or sinkFile
+	Type string `yaml:"type"`
+
+	// file to append the readings to, as JSON lines, see cmd/waveplus_backfill to replay them into Prometheus
+	Path string `yaml:"path"`
+}
+
+// how every flag maps onto the config
+var flagSetters = map[string]func(cfg *config) error{
+	"listen-address":     func(cfg *config) error { cfg.ListenAddress = *listenAddr; return nil },
+	"read-int":           func(cfg *config) error { cfg.ReadInterval = *readInterval; return nil },
+	"scan-dur":           func(cfg *config) error { cfg.ScanDuration = *scanDuration; return nil },
+	"retries":            func(cfg *config) error { cfg.Retries.MaxAttempts = *retries; return nil },
+	"retry-delay":        func(cfg *config) error { cfg.Retries.Delay = *retryDelay; return nil },
+	"retry-max-delay":    func(cfg *config) error { cfg.Retries.MaxDelay = *retryMaxDelay; return nil },
+	"retry-jitter":       func(cfg *config) error { cfg.Retries.Jitter = *retryJitter; return nil },
+	"debug":              func(cfg *config) error { cfg.Debug = *debug; return nil },
+	"rescan-int":         func(cfg *config) error { cfg.RescanInterval = *rescanInterval; return nil },
+	"expire-after":       func(cfg *config) error { cfg.ExpireAfter = *expireAfter; return nil },
+	"read-concurrency":   func(cfg *config) error { cfg.ReadConcurrency = *readConcurrency; return nil },
+	"read-timeout":       func(cfg *config) error { cfg.ReadTimeout = *readTimeout; return nil },
+	"refresh-int":        func(cfg *config) error { cfg.RefreshInterval = *refreshInterval; return nil },
+	"poll-delay":         func(cfg *config) error { cfg.PollDelay = *pollDelay; return nil },
+	"poll-jitter":        func(cfg *config) error { cfg.PollJitter = *pollJitter; return nil },
+	"diagnostic-metrics": func(cfg *config) error { cfg.DiagnosticMetrics = *diagnostics; return nil },
+	"adapters": func(cfg *config) error {
+		cfg.Adapters = nil
+		for _, adapter := range strings.Split(*adapters, ",") {
+			if adapter = strings.TrimSpace(adapter); adapter != "" {
+				cfg.Adapters = append(cfg.Adapters, adapter)
+			}
+		}
+		return nil
+	},
+	// the sensors in the file keep their other settings
+	"sensors": func(cfg *config) error {
+		knownSensors, err := parseSensors(*sensors)
+		if err != nil {
+			return err
+		}
+		for serialNr, addr := range knownSensors {
+			cfg.sensorRef(serialNr).Address = addr
+		}
+		return nil
+	},
+	"poll-overrides": func(cfg *config) error {
+		overrides, err := parsePollOverrides(*pollOverrides)
+		if err != nil {
+			return err
+		}
+		for serialNr, interval := range overrides {
+			cfg.sensorRef(serialNr).PollInterval = interval
+		}
+		return nil
+	},
+}
+
+func loadConfig() (*config, error) {
+	if err := applyEnv(); err != nil {
+		return nil, err
+	}
+
+	cfg := &config{
+		Sinks: []sinkConfig{{Type: sinkLog}},
+	}
+	if err := cfg.applyFlags(flag.VisitAll); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		data, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read config")
+		}
+		// unknown keys are most likely typos
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", *configFile)
+		}
+	}
+
+	if err := cfg.applyFlags(flag.Visit); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// sets the flags not given on the command line from their environment variables
+func applyEnv() error {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		value, ok := os.LookupEnv(name)
+		if !ok || given[f.Name] || err != nil {
+			return
+		}
+		if setErr := flag.Set(f.Name, value); setErr != nil {
+			err = errors.Wrapf(setErr, "invalid %s", name)
+		}
+	})
+	return err
+}
+
+func (cfg *config) applyFlags(visit func(func(*flag.Flag))) error {
+	var err error
+	visit(func(f *flag.Flag) {
+		if setter, ok := flagSetters[f.Name]; ok && err == nil {
+			if setErr := setter(cfg); setErr != nil {
+				err = errors.Wrapf(setErr, "invalid -%s", f.Name)
+			}
+		}
+	})
+	return err
+}
+
+// reports every problem at once, so that the config can be fixed in one go
+func (cfg *config) validate() error {
+	var problems []string
+	problemf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.ListenAddress == "" {
+		problemf("listen_address: must not be empty")
+	}
+	for _, d := range []struct {
+		name     string
+		value    time.Duration
+		optional bool
+	}{
+		{"read_interval", cfg.ReadInterval, false},
+		{"scan_duration", cfg.ScanDuration, false},
+		{"rescan_interval", cfg.RescanInterval, false},
+		{"expire_after", cfg.ExpireAfter, false},
+		{"read_timeout", cfg.ReadTimeout, false},
+		{"refresh_interval", cfg.RefreshInterval, false},
+		{"poll_delay", cfg.PollDelay, true},
+		{"poll_jitter", cfg.PollJitter, true},
+		{"retries.delay", cfg.Retries.Delay, true},
+		{"retries.max_delay", cfg.Retries.MaxDelay, true},
+	} {
+		switch {
+		case d.value < 0:
+			problemf("%s: must not be negative, got %s", d.name, d.value)
+		case d.value == 0 && !d.optional:
+			problemf("%s: must be positive", d.name)
+		}
+	}
+	if cfg.ReadConcurrency < 1 {
+		problemf("read_concurrency: must be at least 1, got %d", cfg.ReadConcurrency)
+	}
+	if cfg.Retries.MaxAttempts < 1 {
+		problemf("retries.max_attempts: must be at least 1, got %d", cfg.Retries.MaxAttempts)
+	}
+	if cfg.Retries.Jitter < 0 || cfg.Retries.Jitter > 1 {
+		problemf("retries.jitter: must be between 0 and 1, got %g", cfg.Retries.Jitter)
+	}
+
+	if len(cfg.Adapters) == 0 {
+		problemf("adapters: at least one is required")
+	}
+	for i, adapter := range cfg.Adapters {
+		if _, err := adapterID(adapter); err != nil {
+			problemf("adapters[%d]: %s", i, err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, sensor := range cfg.Sensors {
+		switch _, err := strconv.ParseUint(sensor.Serial, 10, 32); {
+		case sensor.Serial == "":
+			problemf("sensors[%d].serial: must not be empty", i)
+		case err != nil:
+			problemf("sensors[%d].serial: %q is not a serial number", i, sensor.Serial)
+		case seen[sensor.Serial]:
+			problemf("sensors[%d].serial: %s is configured more than once", i, sensor.Serial)
+		default:
+			if _, ok := waveplus.ModelBySerialNumber(sensor.Serial); !ok {
+				problemf("sensors[%d].serial: %s is not of a supported model", i, sensor.Serial)
+			}
+		}
+		seen[sensor.Serial] = true
+
+		for name := range sensor.Labels {
+			if err := metrics.ValidateLabelName(name); err != nil {
+				problemf("sensors[%d].labels: %s", i, err)
+			}
+		}
+		if sensor.PollInterval < 0 {
+			problemf("sensors[%d].poll_interval: must not be negative, got %s", i, sensor.PollInterval)
+		}
+	}
+
+	for i, sink := range cfg.Sinks {
+		switch sink.Type {
+		case sinkLog:
+		case sinkFile:
+			if sink.Path == "" {
+				problemf("sinks[%d].path: required for %s sinks", i, sinkFile)
+			}
+		default:
+			problemf("sinks[%d].type: expected %s or %s, got %q", i, sinkLog, sinkFile, sink.Type)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// the labels of all the configured sensors
+func (cfg *config) sensorLabels() []metrics.SensorLabels {
+	var labels []metrics.SensorLabels
+	for _, sensor := range cfg.Sensors {
+		labels = append(labels, sensor.SensorLabels)
+	}
+	return labels
+}
+
+// the settings of the sensor, zero ones if it is not configured
+func (cfg *config) sensor(serialNr string) sensorConfig {
+	for _, sensor := range cfg.Sensors {
+		if sensor.Serial == serialNr {
+			return sensor
+		}
+	}
+	return sensorConfig{Serial: serialNr}
+}
+
+// the settings of the sensor to modify, added if it is not configured
+func (cfg *config) sensorRef(serialNr string) *sensorConfig {
+	for i := range cfg.Sensors {
+		if cfg.Sensors[i].Serial == serialNr {
+			return &cfg.Sensors[i]
+		}
+	}
+	cfg.Sensors = append(cfg.Sensors, sensorConfig{Serial: serialNr})
+	return &cfg.Sensors[len(cfg.Sensors)-1]
+}
+
+// applies the offsets to the valid measurements only
+func (c calibration) apply(values airthings.SensorValues) airthings.SensorValues {
+	if values.Has(airthings.FieldHumidity) {
+		values.Humidity += c.Humidity
+	}
+	if values.Has(airthings.FieldTemperature) {
+		values.Temperature += c.Temperature
+	}
+	if values.Has(airthings.FieldAtmPressure) {
+		values.AtmPressure += c.AtmPressure
+	}
+	if values.Has(airthings.FieldCo2Level) {
+		values.Co2Level += c.Co2Level
+	}
+	if values.Has(airthings.FieldVocLevel) {
+		values.VocLevel += c.VocLevel
+	}
+	return values
+}
+
+// parses hciN
+func adapterID(adapter string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(adapter, "hci"))
+	if err != nil || !strings.HasPrefix(adapter, "hci") {
+		return 0, errors.Errorf("invalid adapter %q, expected hciN", adapter)
+	}
+	return id, nil
+}
+
+// parses comma separated serialNr=interval pairs
+func parsePollOverrides(pairs string) (map[string]time.Duration, error) {
+	overrides := map[string]time.Duration{}
+	for _, pair := range strings.Split(pairs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid poll override %q, expected serialNr=interval", pair)
+		}
+		interval, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid poll override %q", pair)
+		}
+		overrides[parts[0]] = interval
+	}
+	return overrides, nil
+}
+
+// parses comma separated serialNr=address pairs
+func parseSensors(pairs string) (map[string]string, error) {
+	knownSensors := map[string]string{}
+	for _, pair := range strings.Split(pairs, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid sensor %q, expected serialNr=address", pair)
+		}
+		knownSensors[parts[0]] = parts[1]
+	}
+	return knownSensors, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alepar/airthings/airthings/metrics"
+)
+
+// resets the exporter flags as if none were given, then parses args as the command line
+func parseFlags(t *testing.T, args ...string) {
+	original := flag.CommandLine
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	original.VisitAll(func(f *flag.Flag) {
+		// leaves out the flags of the test binary
+		if _, ok := flagSetters[f.Name]; !ok && f.Name != "config" {
+			return
+		}
+		if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatalf("failed to reset -%s: %s", f.Name, err)
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	flag.CommandLine = fs
+	t.Cleanup(func() { flag.CommandLine = original })
+
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("failed to parse %v: %s", args, err)
+	}
+}
+
+func setenv(t *testing.T, name string, value string) {
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func writeConfigFile(t *testing.T, yaml string) string {
+	f, err := ioutil.TempFile("", "waveplus-config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	if _, err := f.WriteString(yaml); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+read_interval: 60s
+scan_duration: 10s
+rescan_interval: 2h
+`)
+	setenv(t, "WAVEPLUS_SCAN_DUR", "7s")
+	setenv(t, "WAVEPLUS_RESCAN_INT", "3h")
+	parseFlags(t, "-config", path, "-rescan-int", "4h")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, d := range []struct {
+		name     string
+		got      time.Duration
+		expected time.Duration
+	}{
+		{"expire_after from the flag default", cfg.ExpireAfter, 24 * time.Hour},
+		{"read_interval from the file", cfg.ReadInterval, 60 * time.Second},
+		{"scan_duration from the environment", cfg.ScanDuration, 7 * time.Second},
+		{"rescan_interval from the command line", cfg.RescanInterval, 4 * time.Hour},
+	} {
+		if d.got != d.expected {
+			t.Errorf("expected %s %s, got %s", d.name, d.expected, d.got)
+		}
+	}
+}
+
+func TestLoadConfigMergesSensors(t *testing.T) {
+	path := writeConfigFile(t, `
+sensors:
+  - serial: "2930000001"
+    name: bedroom
+    address: "00:11:22:33:44:99"
+    calibration:
+      temperature: -0.5
+`)
+	parseFlags(t, "-config", path,
+		"-sensors", "2930000001=00:11:22:33:44:01,2930000002=00:11:22:33:44:02",
+		"-poll-overrides", "2930000001=10m")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(cfg.Sensors) != 2 {
+		t.Fatalf("expected the configured sensor and the one added by -sensors, got %+v", cfg.Sensors)
+	}
+	bedroom := cfg.sensor("2930000001")
+	if bedroom.Name != "bedroom" || bedroom.Calibration.Temperature != -0.5 {
+		t.Errorf("expected the settings from the file to be kept, got %+v", bedroom)
+	}
+	if bedroom.Address != "00:11:22:33:44:01" || bedroom.PollInterval != 10*time.Minute {
+		t.Errorf("expected the address and poll interval from the flags, got %+v", bedroom)
+	}
+	if added := cfg.sensor("2930000002"); added.Address != "00:11:22:33:44:02" {
+		t.Errorf("expected the sensor added by -sensors, got %+v", added)
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	parseFlags(t, "-config", writeConfigFile(t, "read_intreval: 60s\n"))
+
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "read_intreval") {
+		t.Errorf("expected the typo to be reported, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	parseFlags(t)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("expected the flag defaults to be valid, got %s", err)
+	}
+
+	cfg.ReadInterval = 0
+	cfg.PollJitter = -time.Second
+	cfg.ReadConcurrency = 0
+	cfg.Retries.Jitter = 2
+	cfg.Adapters = []string{"hci0", "bt1"}
+	cfg.Sensors = []sensorConfig{
+		{Serial: "2930000001"},
+		{Serial: "2930000001"},
+		{Serial: "bedroom"},
+		{Serial: "1234567890"},
+		{Serial: "2930000002", SensorLabels: metrics.SensorLabels{Labels: map[string]string{"model": "x"}}},
+	}
+	cfg.Sinks = []sinkConfig{{Type: sinkFile}, {Type: "syslog"}}
+
+	err = cfg.validate()
+	if err == nil {
+		t.Fatal("expected the problems to be reported")
+	}
+	for _, problem := range []string{
+		"read_interval: must be positive",
+		"poll_jitter: must not be negative, got -1s",
+		"read_concurrency: must be at least 1, got 0",
+		"retries.jitter: must be between 0 and 1, got 2",
+		`adapters[1]: invalid adapter "bt1", expected hciN`,
+		"sensors[1].serial: 2930000001 is configured more than once",
+		`sensors[2].serial: "bedroom" is not a serial number`,
+		"sensors[3].serial: 1234567890 is not of a supported model",
+		`sensors[4].labels: "model" is a reserved label name`,
+		"sinks[0].path: required for file sinks",
+		`sinks[1].type: expected log or file, got "syslog"`,
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported, got %s", problem, err)
+		}
+	}
+	if count := strings.Count(err.Error(), "; ") + 1; count != 11 {
+		t.Errorf("expected 11 problems, got %d: %s", count, err)
+	}
+}
@@ -4,8 +4,9 @@ go 1.14
 
 require (
 	github.com/go-ble/ble v0.0.0-20200120171844-0a73a9da88eb
-	github.com/pkg/errors v0.8.1
-	github.com/prometheus/client_golang v1.5.1 // indirect
+	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.5.1
 	github.com/prometheus/common v0.9.1
 	github.com/sirupsen/logrus v1.4.2
+	gopkg.in/yaml.v2 v2.4.0
 )
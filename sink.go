@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/alepar/airthings/airthings"
+	"github.com/alepar/airthings/airthings/store"
+)
+
+// sink receives every successful reading, calibrated, besides the Prometheus gauges
+type sink interface {
+	Write(reading airthings.Reading, sensor sensorConfig) error
+	Close() error
+}
+
+func openSinks(configs []sinkConfig) ([]sink, error) {
+	var sinks []sink
+	for _, c := range configs {
+		switch c.Type {
+		case sinkLog:
+			sinks = append(sinks, logSink{})
+		case sinkFile:
+			f, err := os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, errors.Wrapf(err, "failed to open sink %s", c.Path)
+			}
+			sinks = append(sinks, &fileSink{f: f, w: store.NewWriter(f)})
+		}
+	}
+	return sinks, nil
+}
+
+func closeSinks(sinks []sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Errorf("failed to close sink: %s", err)
+		}
+	}
+}
+
+type logSink struct{}
+
+func (logSink) Write(reading airthings.Reading, sensor sensorConfig) error {
+	valuesAsJson, err := json.Marshal(reading.SensorValues)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal values")
+	}
+	log.Printf("Received from %s: %s", reading.SerialNumber, valuesAsJson)
+	return nil
+}
+
+func (logSink) Close() error {
+	return nil
+}
+
+// appends one JSON object per reading, see store.Record
+type fileSink struct {
+	f *os.File
+	w *store.Writer
+}
+
+func (s *fileSink) Write(reading airthings.Reading, sensor sensorConfig) error {
+	return s.w.Write(store.NewRecord(reading, sensor.SensorLabels))
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-ble/ble"
@@ -15,49 +20,66 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/alepar/airthings/airthings"
 	"github.com/alepar/airthings/airthings/waveplus"
+	"github.com/alepar/airthings/airthings/waveplus/frame"
 )
 
-// CLI args
+// CLI args, see config for how they combine with the config file
 var (
-	listenAddr   = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
-	readInterval = flag.Duration("read-int", 150*time.Second, "time interval between sensor reads")
-	scanDuration = flag.Duration("scan-dur", 5*time.Second, "scan duration")
-	retries      = flag.Int("retries", 5, "max number of tries in case of BLE errors")
-	debug        = flag.Bool("debug", false, "enable debug logging")
+	configFile      = flag.String("config", "", "YAML config file, overridden by the flags and environment variables given")
+	listenAddr      = flag.String("listen-address", ":8080", "The address to listen on for HTTP requests.")
+	readInterval    = flag.Duration("read-int", 150*time.Second, "time interval between sensor reads, until the sensor refresh cycle is detected")
+	scanDuration    = flag.Duration("scan-dur", 5*time.Second, "scan duration")
+	retries         = flag.Int("retries", 5, "max number of tries in case of BLE errors")
+	retryDelay      = flag.Duration("retry-delay", 1*time.Second, "delay before the first retry, doubled for every next one")
+	retryMaxDelay   = flag.Duration("retry-max-delay", 30*time.Second, "max delay between retries")
+	retryJitter     = flag.Float64("retry-jitter", 0.2, "fraction of the retry delay that is randomized")
+	debug           = flag.Bool("debug", false, "enable debug logging")
+	adapters        = flag.String("adapters", "hci0", "comma separated list of BLE adapters to use, e.g. hci0,hci1")
+	sensors         = flag.String("sensors", "", "comma separated serialNr=address pairs of known sensors, read without scanning for them")
+	rescanInterval  = flag.Duration("rescan-int", 1*time.Hour, "time interval between scans for new sensors")
+	expireAfter     = flag.Duration("expire-after", 24*time.Hour, "forget discovered sensors not seen for this long")
+	readConcurrency = flag.Int("read-concurrency", 1, "max number of sensors read at the same time through one adapter, which still connects to one at a time")
+	readTimeout     = flag.Duration("read-timeout", 2*time.Minute, "max time to read one sensor, retries included")
+	refreshInterval = flag.Duration("refresh-int", 5*time.Minute, "how often sensors refresh their values")
+	pollDelay       = flag.Duration("poll-delay", 15*time.Second, "how long after the expected sensor refresh to read it")
+	pollJitter      = flag.Duration("poll-jitter", 10*time.Second, "max random delay added to every sensor read")
+	pollOverrides   = flag.String("poll-overrides", "", "comma separated serialNr=interval pairs of fixed read intervals")
+	diagnostics     = flag.Bool("diagnostic-metrics", false, "also expose the frame fields of unknown meaning, as air_raw_field")
 )
 
 // metrics to expose to Prometheus
+var collector = newSensorCollector()
+
+// operational metrics, besides the per sensor ones in collector
 var (
-	gaugeHumidity    = newGauge("air_humidity", "Humidity (units: % of relative Humidity)")
-	gaugeRadonShort  = newGauge("air_radon_short", "Radon Short Term estimate (units: Bq/m3)")
-	gaugeRadonLong   = newGauge("air_radon_long", "Radon Long Term estimate (units: Bq/m3)")
-	gaugeTemperature = newGauge("air_temperature", "Air Temperature (units: degrees Celsius)")
-	gaugeAtmPressure = newGauge("air_atm_pressure", "Atmospheric Pressure (units: hPa)")
-	gaugeCo2Level    = newGauge("air_co2_level", "Air Carbon Dioxide level (units: ppm)")
-	gaugeVocLevel    = newGauge("air_voc_level", "Air Volatile Organic Compounds level (units: ppb)")
+	scanDurationHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "air_scan_duration_seconds",
+		Help:    "Duration of the scans for sensors, retries included",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+	phaseDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "air_read_phase_duration_seconds",
+		Help:    "Duration of the successful connects, discoveries and reads of the sensor values",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"phase"})
+	sensorsFoundGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "air_sensors_found",
+		Help: "Sensors found by the last successful scan",
+	})
+	adapterReopensCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "air_adapter_reopens_total",
+		Help: "Times the BLE adapters were reopened after a failure",
+	})
 )
 
-func newGauge(name string, help string) *prometheus.GaugeVec {
-	return prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: name,
-			Help: help,
-		},
-		[]string{"serial_number"},
-	)
-}
-
 func init() {
-	flag.Parse()
-
-	prometheus.MustRegister(gaugeHumidity)
-	prometheus.MustRegister(gaugeRadonShort)
-	prometheus.MustRegister(gaugeRadonLong)
-	prometheus.MustRegister(gaugeTemperature)
-	prometheus.MustRegister(gaugeAtmPressure)
-	prometheus.MustRegister(gaugeCo2Level)
-	prometheus.MustRegister(gaugeVocLevel)
+	prometheus.MustRegister(collector)
+	prometheus.MustRegister(scanDurationHistogram)
+	prometheus.MustRegister(phaseDurationHistogram)
+	prometheus.MustRegister(sensorsFoundGauge)
+	prometheus.MustRegister(adapterReopensCounter)
 
 	// Add Go module build info.
 	prometheus.MustRegister(prometheus.NewBuildInfoCollector())
@@ -67,12 +89,23 @@ func init() {
 		FullTimestamp: true,
 	}
 	log.SetFormatter(formatter)
-	if *debug {
-		log.SetLevel(log.DebugLevel)
-	}
 }
 
 func main() {
+	flag.Parse()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.Debugf("configuration: %+v", *cfg)
+	sinks, err := openSinks(cfg.Sinks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Expose the metrics to Prometheus
 	go func() {
 		http.Handle("/metrics", promhttp.HandlerFor(
@@ -82,19 +115,23 @@ func main() {
 				EnableOpenMetrics: true,
 			},
 		))
-		log.Panic(http.ListenAndServe(*listenAddr, nil))
+		log.Panic(http.ListenAndServe(cfg.ListenAddress, nil))
 	}()
 
 	watchdogChannel := make(chan bool)
-	maxTimeBetweenReads := math.Max(
-		(150 * time.Second).Seconds(), // Wave+ updates values every 5min, so we should be reading ~twice as fast
-		3*(readInterval.Seconds()+scanDuration.Seconds()),      // or a bit slower than the requested read frequency
-	)
+	// the limit changes with the config on reloads
+	watchdogLimits := make(chan float64)
 	// Start the watchdog thread
 	go func() {
+		maxTimeBetweenReads := watchdogLimit(cfg)
 		heartbeatsSinceLastRead := 0
 		for {
-			val := <-watchdogChannel
+			var val bool
+			select {
+			case maxTimeBetweenReads = <-watchdogLimits:
+				continue
+			case val = <-watchdogChannel:
+			}
 			if val {
 				// successful sensor read
 				heartbeatsSinceLastRead = 0
@@ -108,9 +145,9 @@ func main() {
 		}
 	}()
 	// Start the heartbeat thread
-	go func (){
+	go func() {
 		for {
-			time.Sleep(1*time.Second)
+			time.Sleep(1 * time.Second)
 			watchdogChannel <- false
 		}
 	}()
@@ -118,97 +155,462 @@ func main() {
 	// let's open BLE device and hang on to it
 	// not great if we need to share BLE device with other apps
 	// but it prevents us from freezing periodically if we try to open/close BLE device every time we want to read from sensors
-	openBleDevice()
+	devices := openBleDevices(cfg.Adapters)
+	transport := newTransport(devices)
 
-	for {
-		err := scanAndReceive()
-		if err != nil {
-			log.Error("failed to scanAndReceive: %s", err)
+	// cancelled on SIGINT/SIGTERM, so that a hung BLE operation does not hold up the shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		log.Infof("received %s, shutting down", sig)
+		cancel()
+	}()
+
+	// the config is reloaded between read passes, so that every pass sees one config
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+
+	e := newExporter(cfg, transport, sinks)
+	for ctx.Err() == nil {
+		pass, err := e.receive(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+
+		switch {
+		case err == nil:
+			if pass.read > 0 || pass.due == 0 {
+				watchdogChannel <- true // signal a successful read, or a pass with no sensor due
+			}
+		case errors.Is(err, waveplus.ErrAdapterDown):
+			log.Errorf("failed to scan and receive: %s", err)
 
 			log.Info("attempting to reopen BLE device in 5s")
 			time.Sleep(5 * time.Second)
 
-			log.Debugf("removing all services")
-			err := ble.RemoveAllServices()
-			if err != nil {
-				log.Errorf("failed to remove all services: %s", err)
-			} else {
-				log.Debugf("removed all services")
-			}
+			adapterReopensCounter.Inc()
+			closeBleDevices(devices)
+			devices = openBleDevices(cfg.Adapters)
+			transport = newTransport(devices)
+			e.setTransport(transport)
+		default:
+			log.Errorf("failed to scan and receive, will retry: %s", err)
+		}
 
-			log.Debugf("stopping the device")
-			err = ble.Stop()
-			if err != nil {
-				log.Error("failed to stop the device: %s", err)
-			} else {
-				log.Debugf("stopped the device")
-			}
+		// until the next sensor is due, but look for new ones every once in a while
+		sleep := time.Until(pass.next)
+		if pass.known == 0 || sleep > e.cfg.ReadInterval {
+			sleep = e.cfg.ReadInterval
+		}
+		if sleep < time.Second {
+			sleep = time.Second
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(sleep):
+		case <-reloads:
+			e.reload()
+			watchdogLimits <- watchdogLimit(e.cfg)
+		}
+	}
+
+	closeBleDevices(devices)
+	closeSinks(e.sinks)
+}
+
+// max seconds without a successful pass before the watchdog kills the process
+func watchdogLimit(cfg *config) float64 {
+	return math.Max(
+		(150 * time.Second).Seconds(),                             // Wave+ updates values every 5min, so we should be reading ~twice as fast
+		3*(cfg.ReadInterval.Seconds()+cfg.ScanDuration.Seconds()), // or a bit slower than the requested read frequency
+	)
+}
+
+func openBleDevices(adapters []string) []ble.Device {
+	var devices []ble.Device
+	for _, adapter := range adapters {
+		id, err := adapterID(adapter)
+		if err != nil {
+			log.Panic(err)
+		}
 
-			openBleDevice()
+		log.Infof("Opening BLE device %s", adapter)
+		d, err := linux.NewDevice(ble.OptDeviceID(id))
+		if err != nil {
+			log.Panicf("failed to open ble %s: %s", adapter, err)
+		}
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+func closeBleDevices(devices []ble.Device) {
+	for i, device := range devices {
+		log.Debugf("removing all services on device #%d", i)
+		err := device.RemoveAllServices()
+		if err != nil {
+			log.Errorf("failed to remove all services: %s", err)
 		} else {
-			watchdogChannel <- true // signal a successful read from the device
+			log.Debugf("removed all services")
+		}
+
+		log.Debugf("stopping device #%d", i)
+		err = device.Stop()
+		if err != nil {
+			log.Errorf("failed to stop the device: %s", err)
+		} else {
+			log.Debugf("stopped the device")
 		}
-		time.Sleep(*readInterval)
 	}
 }
 
-func openBleDevice() {
-	log.Info("Opening BLE device")
-	d, err := linux.NewDevice()
-	if err != nil {
-		log.Panicf("failed to open ble: %s", err)
+func newScanner(cfg *config, transport waveplus.Transport) airthings.Scanner {
+	return observedScanner{&waveplus.BleScanner{
+		ScanDuration: cfg.ScanDuration,
+		Retries:      cfg.Retries.MaxAttempts,
+		Transport:    transport,
+		RetryPolicy:  newRetryPolicy(cfg),
+	}}
+}
+
+// times the scans that actually use the radio, rather than the ones CachingScanner answers
+type observedScanner struct {
+	airthings.Scanner
+}
+
+func (s observedScanner) Scan() (map[string]airthings.Sensor, error) {
+	return s.ScanContext(context.Background())
+}
+
+func (s observedScanner) ScanContext(ctx context.Context) (map[string]airthings.Sensor, error) {
+	started := time.Now()
+	found, err := s.Scanner.ScanContext(ctx)
+	scanDurationHistogram.Observe(time.Since(started).Seconds())
+	if err == nil {
+		sensorsFoundGauge.Set(float64(len(found)))
 	}
-	ble.SetDefaultDevice(d)
+	return found, err
 }
 
-func scanAndReceive() error {
-	log.Info("scanning...")
+// state kept across read passes
+type exporter struct {
+	cfg       *config
+	sinks     []sink
+	transport *waveplus.MultiTransport
+	scheduler *airthings.ReadScheduler
+	cache     *airthings.CachingScanner
+	planner   *airthings.PollPlanner
 
-	// Scan
-	scanner := waveplus.BleScanner{
-		ScanDuration: *scanDuration,
-		Retries:      *retries,
+	// kept across passes, as they remember the GATT handles
+	sensors map[string]*waveplus.BleSensor
+}
+
+type passResult struct {
+	// sensors known, whether due or not
+	known int
+
+	// sensors due, and read successfully out of these
+	due  int
+	read int
+
+	// when the next sensor is due
+	next time.Time
+}
+
+func newExporter(cfg *config, transport *waveplus.MultiTransport, sinks []sink) *exporter {
+	e := &exporter{
+		cfg:   cfg,
+		sinks: sinks,
+		planner: &airthings.PollPlanner{
+			RefreshInterval:  cfg.RefreshInterval,
+			Delay:            cfg.PollDelay,
+			Jitter:           cfg.PollJitter,
+			FallbackInterval: cfg.ReadInterval,
+			Overrides:        pollIntervals(cfg),
+		},
+		sensors: map[string]*waveplus.BleSensor{},
 	}
-	log.Debugf("scanning for sensors")
-	sensorsMap, err := scanner.Scan()
+	e.setTransport(transport)
+	collector.configure(cfg)
+
+	// configured sensors are read whether the scans find them or not, scanning looks for the other ones
+	for _, sensor := range cfg.Sensors {
+		if sensor.Address != "" {
+			e.cache.Pin(sensor.Serial, newSensor(cfg, sensor.Serial, sensor.Address, transport))
+		}
+	}
+	return e
+}
+
+// reads the config again, and applies it unless it is invalid; the adapters are kept open
+func (e *exporter) reload() {
+	log.Info("reloading configuration")
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Errorf("failed to reload configuration, keeping the current one: %s", err)
+		return
+	}
+	sinks, err := openSinks(cfg.Sinks)
 	if err != nil {
-		return errors.Wrap(err, "failed to scan for sensors: %s")
+		log.Errorf("failed to reload configuration, keeping the current one: %s", err)
+		return
+	}
+
+	// these are only read on startup
+	if cfg.ListenAddress != e.cfg.ListenAddress {
+		log.Warnf("listen_address changed to %s, restart to apply", cfg.ListenAddress)
+		cfg.ListenAddress = e.cfg.ListenAddress
+	}
+	if strings.Join(cfg.Adapters, ",") != strings.Join(e.cfg.Adapters, ",") {
+		log.Warnf("adapters changed to %s, restart to apply", strings.Join(cfg.Adapters, ","))
+		cfg.Adapters = e.cfg.Adapters
+	}
+
+	if cfg.Debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+	collector.configure(cfg)
+
+	// pin the sensors configured anew, release the ones no longer configured
+	pinned := map[string]string{}
+	for _, sensor := range e.cfg.Sensors {
+		if sensor.Address != "" {
+			pinned[sensor.Serial] = sensor.Address
+		}
+	}
+	for _, sensor := range cfg.Sensors {
+		if sensor.Address == "" {
+			continue
+		}
+		if pinned[sensor.Serial] != sensor.Address {
+			e.cache.Pin(sensor.Serial, newSensor(cfg, sensor.Serial, sensor.Address, e.transport))
+		}
+		delete(pinned, sensor.Serial)
 	}
-	log.Debugf("scan finished")
+	for serialNr := range pinned {
+		e.cache.Unpin(serialNr)
+	}
+
+	e.planner.RefreshInterval = cfg.RefreshInterval
+	e.planner.Delay = cfg.PollDelay
+	e.planner.Jitter = cfg.PollJitter
+	e.planner.FallbackInterval = cfg.ReadInterval
+	e.planner.Overrides = pollIntervals(cfg)
+	e.scheduler.Concurrency = cfg.ReadConcurrency
+	e.scheduler.Timeout = cfg.ReadTimeout
+	e.cache.RescanInterval = cfg.RescanInterval
+	e.cache.Expiry = cfg.ExpireAfter
+	e.cache.Scanner = newScanner(cfg, e.transport)
+	for _, sensor := range e.sensors {
+		sensor.ScanDuration = cfg.ScanDuration
+		sensor.Retries = cfg.Retries.MaxAttempts
+		sensor.RetryPolicy = newRetryPolicy(cfg)
+	}
+
+	closeSinks(e.sinks)
+	e.sinks = sinks
+	e.cfg = cfg
+	log.Info("reloaded configuration")
+	log.Debugf("configuration: %+v", *cfg)
+}
+
+// switches to the adapters reopened after a failure
+func (e *exporter) setTransport(transport *waveplus.MultiTransport) {
+	e.transport = transport
+	e.scheduler = newScheduler(e.cfg, transport)
+	if e.cache == nil {
+		e.cache = airthings.NewCachingScanner(newScanner(e.cfg, transport), e.cfg.RescanInterval, e.cfg.ExpireAfter)
+	} else {
+		e.cache.Scanner = newScanner(e.cfg, transport)
+	}
+}
+
+// reads the sensors that are due, connecting to them directly
+func (e *exporter) receive(ctx context.Context) (passResult, error) {
+	sensorsMap, err := e.cache.ScanContext(ctx)
+	if err != nil {
+		if errors.Is(err, waveplus.ErrAdapterDown) {
+			return passResult{}, errors.Wrap(err, "failed to scan for sensors")
+		}
+		log.Errorf("failed to scan for sensors, reading the known ones: %s", err)
+	}
+
+	// the ones that expired from the cache are gone, stop publishing their last state
+	for serialNr := range e.sensors {
+		if _, ok := sensorsMap[serialNr]; !ok {
+			log.Infof("forgetting sensor (serialNr %s), not seen for %s", serialNr, e.cache.Expiry)
+			delete(e.sensors, serialNr)
+			e.planner.Forget(serialNr)
+		}
+	}
+	collector.retain(sensorsMap)
+
+	now := time.Now()
+	entries := e.cache.Entries()
+	toRead := map[string]airthings.Sensor{}
+	for serialNr, cached := range sensorsMap {
+		if entry := entries[serialNr]; entry.FirstSeen.Equal(entry.LastSeen) {
+			modelName := "unknown model"
+			if model, ok := waveplus.ModelBySerialNumber(serialNr); ok {
+				modelName = model.Name
+			}
+			log.Printf("Found: serialNr %s addr %s (%s)", serialNr, cached.Address(), modelName)
+		}
+		if !e.planner.Due(serialNr, now) {
+			continue
+		}
 
-	for serialNr, sensor := range sensorsMap {
-		log.Printf("Found: serialNr %s addr %s", serialNr, sensor.Address())
+		sensor, ok := e.sensors[serialNr]
+		if !ok || sensor.Addr != cached.Address() {
+			sensor = newSensor(e.cfg, serialNr, cached.Address(), e.transport)
+			e.sensors[serialNr] = sensor
+		}
+		// the adapters might have been reopened since
+		sensor.Transport = e.transport
+		toRead[serialNr] = sensor
 	}
 
-	// Receive from every found sensor
-	for serialNr, sensor := range sensorsMap {
-		log.Debugf("receiving sensor values from %s", serialNr)
-		values, err := sensor.Receive()
+	pass := passResult{known: len(sensorsMap), due: len(toRead)}
+	var adapterErr error
+	e.scheduler.ReadAll(ctx, toRead, func(result airthings.ReadResult) {
+		serialNr, reading, err := result.SerialNumber, result.Reading, result.Err
 		if err != nil {
+			collector.failed(serialNr, errorClass(err))
+		}
+		switch {
+		case err == nil:
+			pass.read++
+			e.cache.ReportSeen(serialNr)
+			e.planner.Observe(reading)
+		case errors.Is(err, waveplus.ErrDeviceNotFound), errors.Is(err, waveplus.ErrConnectTimeout):
+			// out of range or out of batteries, stop reporting its last values as current
+			log.Warnf("sensor (serialNr %s) is offline: %s", serialNr, err)
+			collector.offline(serialNr)
+			e.cache.ReportFailure(serialNr)
+			e.planner.Failed(serialNr, time.Now())
+			return
+		case errors.Is(err, waveplus.ErrAdapterDown):
 			log.Errorf("failed to read from sensor (serialNr %s): %s", serialNr, err)
-			continue
+			adapterErr = err
+			e.planner.Failed(serialNr, time.Now())
+			return
+		default:
+			log.Errorf("failed to read from sensor (serialNr %s): %s", serialNr, err)
+			e.planner.Failed(serialNr, time.Now())
+			return
 		}
-		log.Debugf("finished receiving")
+		log.Debugf("finished receiving from %s in %s, rssi %d dBm, raw %x", serialNr, reading.Latency, reading.RSSI, reading.Raw)
 
-		valuesAsJson, err := json.Marshal(values)
-		if err == nil {
-			log.Printf("Received from %s: %s", serialNr, valuesAsJson)
-		} else {
-			log.Printf("Received: <marshall error: %s>", err)
+		sensorCfg := e.cfg.sensor(serialNr)
+		reading.SensorValues = sensorCfg.Calibration.apply(reading.SensorValues)
+		for _, s := range e.sinks {
+			if err := s.Write(reading, sensorCfg); err != nil {
+				log.Errorf("failed to write reading from %s: %s", serialNr, err)
+			}
 		}
 
-		gaugeHumidity.WithLabelValues(serialNr).Set(float64(values.Humidity))
-		gaugeRadonShort.WithLabelValues(serialNr).Set(float64(values.RadonShort))
-		gaugeRadonLong.WithLabelValues(serialNr).Set(float64(values.RadonLong))
-		gaugeTemperature.WithLabelValues(serialNr).Set(float64(values.Temperature))
-		gaugeAtmPressure.WithLabelValues(serialNr).Set(float64(values.AtmPressure))
-		gaugeCo2Level.WithLabelValues(serialNr).Set(float64(values.Co2Level))
-		gaugeVocLevel.WithLabelValues(serialNr).Set(float64(values.VocLevel))
+		collector.update(reading)
 
-		// TODO metric and log for a successful/failed read from sensor
-		// TODO when read failed - stop gauge from being reported to prometheus - ie you get missing data points
 		// TODO how about panicking when all retries exhausted? doublecheck it kills the process? or recovers
+	})
+
+	for serialNr := range sensorsMap {
+		if next := e.planner.Next(serialNr); pass.next.IsZero() || next.Before(pass.next) {
+			pass.next = next
+		}
 	}
 
-	return nil
+	// let the caller reopen the adapters, once every sensor had its chance
+	return pass, adapterErr
+}
+
+// reads concurrently across the adapters, each sensor counting against the adapter that hears it best
+func newScheduler(cfg *config, transport *waveplus.MultiTransport) *airthings.ReadScheduler {
+	return &airthings.ReadScheduler{
+		Concurrency: cfg.ReadConcurrency,
+		Timeout:     cfg.ReadTimeout,
+		Group: func(serialNr string, sensor airthings.Sensor) string {
+			return strconv.Itoa(transport.Route(sensor.Address())[0])
+		},
+	}
+}
+
+func newSensor(cfg *config, serialNr string, addr string, transport waveplus.Transport) *waveplus.BleSensor {
+	sensor := waveplus.NewSensor(serialNr, addr)
+	sensor.ScanDuration = cfg.ScanDuration
+	sensor.Retries = cfg.Retries.MaxAttempts
+	sensor.Transport = transport
+	sensor.RetryPolicy = newRetryPolicy(cfg)
+	sensor.Observe = func(phase waveplus.Phase, d time.Duration, err error) {
+		if phase == waveplus.PhaseConnect {
+			collector.attempted(serialNr)
+		}
+		// failed phases mostly time out, which says little about the latency
+		if err == nil {
+			phaseDurationHistogram.WithLabelValues(string(phase)).Observe(d.Seconds())
+		}
+	}
+	return sensor
+}
+
+// the failure class of a read, for metrics
+func errorClass(err error) string {
+	var versionErr *frame.UnsupportedVersionError
+	switch {
+	case errors.Is(err, waveplus.ErrDeviceNotFound):
+		return "device_not_found"
+	case errors.Is(err, waveplus.ErrConnectTimeout):
+		return "connect_timeout"
+	case errors.Is(err, waveplus.ErrServiceMissing):
+		return "service_missing"
+	case errors.Is(err, waveplus.ErrCharacteristicMissing):
+		return "characteristic_missing"
+	case errors.Is(err, waveplus.ErrShortFrame):
+		return "short_frame"
+	case errors.As(err, &versionErr):
+		return "unsupported_version"
+	case errors.Is(err, waveplus.ErrAdapterDown):
+		return "adapter_down"
+	case errors.Is(err, waveplus.ErrAdapterBusy):
+		return "adapter_busy"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return "other"
+	}
+}
+
+// the fixed read intervals of the sensors that have one
+func pollIntervals(cfg *config) map[string]time.Duration {
+	overrides := map[string]time.Duration{}
+	for _, sensor := range cfg.Sensors {
+		if sensor.PollInterval > 0 {
+			overrides[sensor.Serial] = sensor.PollInterval
+		}
+	}
+	return overrides
+}
+
+func newRetryPolicy(cfg *config) *waveplus.RetryPolicy {
+	return &waveplus.RetryPolicy{
+		MaxAttempts: cfg.Retries.MaxAttempts,
+		BaseDelay:   cfg.Retries.Delay,
+		MaxDelay:    cfg.Retries.MaxDelay,
+		Jitter:      cfg.Retries.Jitter,
+	}
+}
+
+// scans on all the devices, and reads every sensor through the one that hears it best
+func newTransport(devices []ble.Device) *waveplus.MultiTransport {
+	var transports []waveplus.Transport
+	for _, device := range devices {
+		transports = append(transports, waveplus.NewBleTransport(device))
+	}
+	return waveplus.NewMultiTransport(transports...)
 }